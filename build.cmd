@@ -5,4 +5,7 @@ set PATH=E:\Programs\protobuf\bin;%PATH%
 protoc --go_out=. --go_opt=paths=import --go-grpc_out=. --go-grpc_opt=paths=import middleware/class.proto
 protoc --go_out=. --go_opt=paths=import --go-grpc_out=. --go-grpc_opt=paths=import middleware/hasq.proto
 go build -o .bin/class.exe pet/services/cmd/class
-go build -o .bin/hasq.exe pet/services/cmd/hasq
\ No newline at end of file
+go build -o .bin/hasq.exe pet/services/cmd/hasq
+go build -o .bin/migrate.exe pet/services/cmd/migrate
+go build -o .bin/petctl.exe pet/services/cmd/petctl
+go build -o .bin/outbox.exe pet/services/cmd/outbox
\ No newline at end of file
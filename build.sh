@@ -6,4 +6,7 @@ export PATH=~/go/bin:"$PATH"
 protoc --go_out=. --go_opt=paths=import --go-grpc_out=. --go-grpc_opt=paths=import middleware/class.proto
 protoc --go_out=. --go_opt=paths=import --go-grpc_out=. --go-grpc_opt=paths=import middleware/hasq.proto
 go build -o .bin/class pet/services/cmd/class
-go build -o .bin/hasq pet/services/cmd/hasq
\ No newline at end of file
+go build -o .bin/hasq pet/services/cmd/hasq
+go build -o .bin/migrate pet/services/cmd/migrate
+go build -o .bin/petctl pet/services/cmd/petctl
+go build -o .bin/outbox pet/services/cmd/outbox
\ No newline at end of file
@@ -0,0 +1,191 @@
+// Package client оборачивает сервисы class и hasq: одно подключение на
+// сервис, повторы по service config gRPC, срок вызова по умолчанию,
+// передача метаданных и разбор ошибок в типизированные значения.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"pet/middleware/class"
+	"pet/middleware/hasq"
+)
+
+const (
+	// RequestIdHeader заголовок метаданных с идентификатором запроса, тот же,
+	// что принимают и возвращают серверы
+	RequestIdHeader = "x-request-id"
+
+	authorizationHeader = "authorization"
+
+	DefaultClassAddress = "localhost:51051"
+	DefaultHasqAddress  = "localhost:52051"
+	DefaultTimeout      = 10 * time.Second
+)
+
+// serviceConfig повторяет только вызовы без побочных эффектов: справочники
+// class целиком, поиск и проверку токенов и журнала аудита hasq. Создание токена, ключа и
+// владение не повторяются, их повтор после потерянного ответа меняет цепочку.
+// Отказ ограничителя частоты (ResourceExhausted) тоже не повторяется, чтобы
+// повторы не усиливали нагрузку, от которой он защищает.
+const serviceConfig = `{
+  "methodConfig": [{
+    "name": [
+      {"service": "class.Service"},
+      {"service": "hasq.Service", "method": "SearchToken"},
+      {"service": "hasq.Service", "method": "Validate"},
+      {"service": "hasq.Service", "method": "AuditEvents"},
+      {"service": "hasq.Service", "method": "VerifyAudit"}
+    ],
+    "retryPolicy": {
+      "maxAttempts": 4,
+      "initialBackoff": "0.1s",
+      "maxBackoff": "2s",
+      "backoffMultiplier": 2,
+      "retryableStatusCodes": ["UNAVAILABLE"]
+    }
+  }]
+}`
+
+// Config параметры подключения к сервисам
+type Config struct {
+	ClassAddress string
+	HasqAddress  string
+	// Timeout срок вызова, если у контекста нет своего; 0 отключает его
+	Timeout time.Duration
+	// Metadata добавляется к каждому вызову
+	Metadata map[string]string
+	// Token JWT, передаваемый в заголовке authorization
+	Token string
+	// DialOptions добавляются после настроек клиента, например транспорт TLS
+	DialOptions []grpc.DialOption
+}
+
+// DefaultConfig адреса сервисов из docker-compose и срок DefaultTimeout
+func DefaultConfig() Config {
+	return Config{
+		ClassAddress: DefaultClassAddress,
+		HasqAddress:  DefaultHasqAddress,
+		Timeout:      DefaultTimeout,
+	}
+}
+
+// Client подключения к сервисам class и hasq. Безопасен для одновременного
+// использования.
+type Client struct {
+	cfg       Config
+	classConn *grpc.ClientConn
+	hasqConn  *grpc.ClientConn
+	class     class.ServiceClient
+	hasq      hasq.ServiceClient
+}
+
+// New создает клиента. Подключение устанавливается при первом вызове.
+func New(cfg Config) (*Client, error) {
+	if cfg.ClassAddress == "" || cfg.HasqAddress == "" {
+		return nil, errors.New("class and hasq addresses are required")
+	}
+	c := &Client{cfg: cfg}
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithChainUnaryInterceptor(c.metadataUnary, c.deadlineUnary, errorUnary),
+	}, cfg.DialOptions...)
+	var err error
+	if c.classConn, err = grpc.NewClient(cfg.ClassAddress, opts...); err != nil {
+		return nil, fmt.Errorf("class client: %w", err)
+	}
+	if c.hasqConn, err = grpc.NewClient(cfg.HasqAddress, opts...); err != nil {
+		_ = c.classConn.Close()
+		return nil, fmt.Errorf("hasq client: %w", err)
+	}
+	c.class = class.NewServiceClient(c.classConn)
+	c.hasq = hasq.NewServiceClient(c.hasqConn)
+	return c, nil
+}
+
+// Class клиент сервиса class с настройками Client
+func (c *Client) Class() class.ServiceClient {
+	return c.class
+}
+
+// Hasq клиент сервиса hasq с настройками Client
+func (c *Client) Hasq() hasq.ServiceClient {
+	return c.hasq
+}
+
+// Close закрывает подключения к сервисам
+func (c *Client) Close() error {
+	return errors.Join(c.classConn.Close(), c.hasqConn.Close())
+}
+
+type requestIdKey struct{}
+
+// WithRequestId задает идентификатор запроса для вызовов с контекстом ctx
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// requestId идентификатор из WithRequestId, исходящих метаданных или
+// входящего вызова, чтобы сервис, вызывающий другой, сохранял его
+func requestId(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIdKey{}).(string); ok && id != "" {
+		return id
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if values := md.Get(RequestIdHeader); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIdHeader); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func (c *Client) metadataUnary(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for key, value := range c.cfg.Metadata {
+		if len(md.Get(key)) == 0 {
+			md.Set(key, value)
+		}
+	}
+	if c.cfg.Token != "" && len(md.Get(authorizationHeader)) == 0 {
+		md.Set(authorizationHeader, "Bearer "+c.cfg.Token)
+	}
+	id := requestId(ctx)
+	if id == "" {
+		id = uuid.NewString()
+	}
+	md.Set(RequestIdHeader, id)
+	return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+}
+
+func (c *Client) deadlineUnary(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if _, ok := ctx.Deadline(); !ok && c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func errorUnary(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err != nil {
+		return newError(method, requestId(ctx), err)
+	}
+	return nil
+}
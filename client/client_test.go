@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"pet/middleware/class"
+	"pet/middleware/hasq"
+)
+
+// fakeHasq сервис hasq с одним токеном и счетчиками вызовов
+type fakeHasq struct {
+	hasq.UnimplementedServiceServer
+	mu       sync.Mutex
+	failures int
+	calls    map[string]int
+	md       metadata.MD
+	deadline time.Duration
+	owner    string
+	keys     uint64
+}
+
+func (s *fakeHasq) call(ctx context.Context, method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[method]++
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	if d, ok := ctx.Deadline(); ok {
+		s.deadline = time.Until(d)
+	}
+}
+
+func (s *fakeHasq) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+func (s *fakeHasq) SearchToken(ctx context.Context, ts *hasq.TokenSearch) (*hasq.TokenReply, error) {
+	s.call(ctx, "SearchToken")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return nil, status.Error(codes.Unavailable, "try later")
+	}
+	if ts.GetTokenId() != "t1" {
+		return nil, status.Error(codes.NotFound, "token not found")
+	}
+	return &hasq.TokenReply{TokenId: "t1", Hash: "h1"}, nil
+}
+
+func (s *fakeHasq) CreateToken(ctx context.Context, _ *hasq.TokenCreate) (*hasq.TokenReply, error) {
+	s.call(ctx, "CreateToken")
+	return nil, status.Error(codes.Unavailable, "try later")
+}
+
+func (s *fakeHasq) CreateKey(ctx context.Context, kc *hasq.KeyCreate) (*hasq.KeyCreateReply, error) {
+	s.call(ctx, "CreateKey")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys++
+	return &hasq.KeyCreateReply{KeyId: "k1", Hash: "kh1"}, nil
+}
+
+func (s *fakeHasq) Owned(ctx context.Context, own *hasq.OwnerCreate) (*hasq.OwnerCreateReply, error) {
+	s.call(ctx, "Owned")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner == own.UserId {
+		return nil, status.Error(codes.AlreadyExists, "token owned by this user")
+	}
+	s.owner = own.UserId
+	return &hasq.OwnerCreateReply{Successful: true}, nil
+}
+
+func (s *fakeHasq) Validate(ctx context.Context, _ *hasq.ChainValidate) (*hasq.ChainValidateReply, error) {
+	s.call(ctx, "Validate")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &hasq.ChainValidateReply{Successful: true, OwnerId: s.owner, LastNum: s.keys}, nil
+}
+
+// fakeClass сервис class с классом из пяти значений
+type fakeClass struct {
+	class.UnimplementedServiceServer
+}
+
+func (fakeClass) Elements(_ context.Context, req *class.ClassElementRequest) (*class.ClassElementReply, error) {
+	keys := []string{"a", "b", "c", "d", "e"}
+	start := 0
+	if req.PageToken != nil {
+		for i, key := range keys {
+			if key == req.GetPageToken() {
+				start = i + 1
+			}
+		}
+	}
+	end := min(start+2, len(keys))
+	reply := &class.ClassElementReply{Name: req.Name, Eof: end == len(keys)}
+	for _, key := range keys[start:end] {
+		reply.Elements = append(reply.Elements, &class.ClassElement{Key: key})
+	}
+	if !reply.Eof {
+		reply.NextPageToken = keys[end-1]
+	}
+	return reply, nil
+}
+
+func newTestClient(t *testing.T, cfg Config) (*Client, *fakeHasq) {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	h := &fakeHasq{calls: make(map[string]int)}
+	hasq.RegisterServiceServer(server, h)
+	class.RegisterServiceServer(server, fakeClass{})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	cfg.ClassAddress, cfg.HasqAddress = "passthrough:///bufnet", "passthrough:///bufnet"
+	cfg.DialOptions = append(cfg.DialOptions, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c, h
+}
+
+func TestClient_Retry(t *testing.T) {
+	c, h := newTestClient(t, DefaultConfig())
+	ctx := context.Background()
+	h.failures = 2
+	if _, err := c.Hasq().SearchToken(ctx, &hasq.TokenSearch{Search: &hasq.TokenSearch_TokenId{TokenId: "t1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := h.count("SearchToken"); n != 3 {
+		t.Fatalf("3 attempts expected, got %d", n)
+	}
+	_, err := c.Hasq().CreateToken(ctx, &hasq.TokenCreate{Title: "t"})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("ErrUnavailable expected, got %v", err)
+	}
+	if n := h.count("CreateToken"); n != 1 {
+		t.Fatalf("CreateToken must not be retried, got %d attempts", n)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	c, _ := newTestClient(t, DefaultConfig())
+	ctx := WithRequestId(context.Background(), "req-1")
+	_, err := c.Hasq().SearchToken(ctx, &hasq.TokenSearch{Search: &hasq.TokenSearch_TokenId{TokenId: "t2"}})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ErrNotFound expected, got %v", err)
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("*Error expected, got %T", err)
+	}
+	if e.Method != "/hasq.Service/SearchToken" || e.RequestId != "req-1" || e.Message != "token not found" {
+		t.Fatalf("unexpected error %+v", e)
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("status code must be kept, got %s", status.Code(err))
+	}
+}
+
+func TestClient_DeadlineAndMetadata(t *testing.T) {
+	c, h := newTestClient(t, Config{Timeout: time.Minute, Metadata: map[string]string{"x-client": "test"}})
+	search := &hasq.TokenSearch{Search: &hasq.TokenSearch_TokenId{TokenId: "t1"}}
+	if _, err := c.Hasq().SearchToken(context.Background(), search); err != nil {
+		t.Fatal(err)
+	}
+	if h.deadline <= 50*time.Second || h.deadline > time.Minute {
+		t.Fatalf("default deadline expected, got %s", h.deadline)
+	}
+	if h.md.Get("x-client")[0] != "test" || h.md.Get(RequestIdHeader)[0] == "" {
+		t.Fatalf("unexpected metadata %v", h.md)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// Идентификатор входящего вызова передается дальше
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RequestIdHeader, "req-2"))
+	if _, err := c.Hasq().SearchToken(ctx, search); err != nil {
+		t.Fatal(err)
+	}
+	if h.deadline > time.Second {
+		t.Fatalf("caller deadline must be kept, got %s", h.deadline)
+	}
+	if id := h.md.Get(RequestIdHeader)[0]; id != "req-2" {
+		t.Fatalf("propagated request id expected, got %q", id)
+	}
+}
+
+func TestClient_TakeOwnership(t *testing.T) {
+	c, h := newTestClient(t, DefaultConfig())
+	ctx := context.Background()
+	ownership, err := c.TakeOwnership(ctx, "u1", "t1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ownership.KeyId != "k1" || ownership.LastNum != 1 {
+		t.Fatalf("unexpected ownership %+v", ownership)
+	}
+	if _, err = c.TakeOwnership(ctx, "u1", "t1", "secret"); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("ErrAlreadyExists expected, got %v", err)
+	}
+	if n := h.count("Validate"); n != 1 {
+		t.Fatalf("one validation expected, got %d", n)
+	}
+}
+
+func TestClient_AllElements(t *testing.T) {
+	c, _ := newTestClient(t, DefaultConfig())
+	elements, err := c.AllElements(context.Background(), &class.ClassElementRequest{Name: "sex"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys string
+	for _, e := range elements {
+		keys += e.Key
+	}
+	if keys != "abcde" {
+		t.Fatalf("unexpected elements %q", keys)
+	}
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Ошибки по кодам gRPC для проверки через errors.Is
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrInvalidArgument    = errors.New("invalid argument")
+	ErrAlreadyExists      = errors.New("already exists")
+	ErrFailedPrecondition = errors.New("failed precondition")
+	ErrUnauthenticated    = errors.New("unauthenticated")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrResourceExhausted  = errors.New("resource exhausted")
+	ErrUnavailable        = errors.New("unavailable")
+	ErrDeadlineExceeded   = errors.New("deadline exceeded")
+	ErrCanceled           = errors.New("canceled")
+	ErrInternal           = errors.New("internal")
+)
+
+var codeErrors = map[codes.Code]error{
+	codes.NotFound:           ErrNotFound,
+	codes.InvalidArgument:    ErrInvalidArgument,
+	codes.OutOfRange:         ErrInvalidArgument,
+	codes.AlreadyExists:      ErrAlreadyExists,
+	codes.FailedPrecondition: ErrFailedPrecondition,
+	codes.Aborted:            ErrFailedPrecondition,
+	codes.Unauthenticated:    ErrUnauthenticated,
+	codes.PermissionDenied:   ErrPermissionDenied,
+	codes.ResourceExhausted:  ErrResourceExhausted,
+	codes.Unavailable:        ErrUnavailable,
+	codes.DeadlineExceeded:   ErrDeadlineExceeded,
+	codes.Canceled:           ErrCanceled,
+}
+
+// Error ошибка вызова сервиса
+type Error struct {
+	// Method полное имя метода gRPC
+	Method    string
+	Code      codes.Code
+	Message   string
+	RequestId string
+	status    *status.Status
+}
+
+func newError(method, requestId string, err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	return &Error{Method: method, Code: s.Code(), Message: s.Message(), RequestId: requestId, status: s}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s (request %s)", e.Method, e.Code, e.Message, e.RequestId)
+}
+
+// Unwrap возвращает ошибку пакета для кода, ErrInternal для остальных
+func (e *Error) Unwrap() error {
+	if err, ok := codeErrors[e.Code]; ok {
+		return err
+	}
+	return ErrInternal
+}
+
+// GRPCStatus сохраняет работу status.Code и status.FromError
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"pet/middleware/class"
+	"pet/middleware/hasq"
+)
+
+// AllElements читает все страницы значений класса по запросу req.
+// Поле PageToken запроса используется как начальная позиция.
+func (c *Client) AllElements(ctx context.Context, req *class.ClassElementRequest) ([]*class.ClassElement, error) {
+	page := proto.Clone(req).(*class.ClassElementRequest)
+	var elements []*class.ClassElement
+	for {
+		reply, err := c.class.Elements(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, reply.Elements...)
+		if reply.Eof || reply.NextPageToken == "" {
+			return elements, nil
+		}
+		page.PageToken = &reply.NextPageToken
+	}
+}
+
+// Ownership результат передачи токена пользователю
+type Ownership struct {
+	KeyId   string `json:"key_id"`
+	KeyHash string `json:"key_hash"`
+	// LastNum номер ключа, которым подтверждено владение
+	LastNum uint64 `json:"last_num"`
+}
+
+// TakeOwnership создает пользователю user ключ токена token по фразе
+// passphrase, закрепляет владение и проверяет цепочку токена. Ошибка
+// ErrFailedPrecondition означает, что после ключа пользователя в цепочке
+// появился чужой, и передачу нужно начать заново.
+func (c *Client) TakeOwnership(ctx context.Context, user, token, passphrase string) (*Ownership, error) {
+	key, err := c.hasq.CreateKey(ctx, &hasq.KeyCreate{UserId: user, TokenId: token, Passphrase: passphrase})
+	if err != nil {
+		return nil, err
+	}
+	owned, err := c.hasq.Owned(ctx, &hasq.OwnerCreate{UserId: user, TokenId: token})
+	if err != nil {
+		return nil, err
+	}
+	if !owned.Successful {
+		return nil, fmt.Errorf("token %s: ownership of user %s not accepted", token, user)
+	}
+	chain, err := c.hasq.Validate(ctx, &hasq.ChainValidate{TokenId: token})
+	if err != nil {
+		return nil, err
+	}
+	if !chain.Successful {
+		return nil, fmt.Errorf("token %s: chain is not valid", token)
+	}
+	if chain.OwnerId != user {
+		return nil, fmt.Errorf("token %s: owned by %s instead of %s", token, chain.OwnerId, user)
+	}
+	return &Ownership{KeyId: key.KeyId, KeyHash: key.Hash, LastNum: chain.LastNum}, nil
+}
@@ -248,6 +248,12 @@ func hash(params ...any) string {
 	return encodeToString(digest(params...))
 }
 
+// Hash returns the upper-case hex SHA3-256 of the concatenated parameters,
+// the same hash that links chain elements.
+func Hash(params ...any) string {
+	return hash(params...)
+}
+
 // encodeToString encodes bytes to a string.
 func encodeToString(data []byte) string {
 	return strings.ToUpper(hex.EncodeToString(data))
@@ -21,8 +21,10 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Service_Classes_FullMethodName  = "/class.Service/Classes"
-	Service_Elements_FullMethodName = "/class.Service/Elements"
+	Service_Classes_FullMethodName   = "/class.Service/Classes"
+	Service_Elements_FullMethodName  = "/class.Service/Elements"
+	Service_Lookup_FullMethodName    = "/class.Service/Lookup"
+	Service_Translate_FullMethodName = "/class.Service/Translate"
 )
 
 // ServiceClient is the client API for Service service.
@@ -31,6 +33,8 @@ const (
 type ServiceClient interface {
 	Classes(ctx context.Context, in *ClassRequest, opts ...grpc.CallOption) (*ClassReply, error)
 	Elements(ctx context.Context, in *ClassElementRequest, opts ...grpc.CallOption) (*ClassElementReply, error)
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupReply, error)
+	Translate(ctx context.Context, in *TranslateRequest, opts ...grpc.CallOption) (*TranslateReply, error)
 }
 
 type serviceClient struct {
@@ -61,12 +65,34 @@ func (c *serviceClient) Elements(ctx context.Context, in *ClassElementRequest, o
 	return out, nil
 }
 
+func (c *serviceClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LookupReply)
+	err := c.cc.Invoke(ctx, Service_Lookup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) Translate(ctx context.Context, in *TranslateRequest, opts ...grpc.CallOption) (*TranslateReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TranslateReply)
+	err := c.cc.Invoke(ctx, Service_Translate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ServiceServer is the server API for Service service.
 // All implementations must embed UnimplementedServiceServer
 // for forward compatibility.
 type ServiceServer interface {
 	Classes(context.Context, *ClassRequest) (*ClassReply, error)
 	Elements(context.Context, *ClassElementRequest) (*ClassElementReply, error)
+	Lookup(context.Context, *LookupRequest) (*LookupReply, error)
+	Translate(context.Context, *TranslateRequest) (*TranslateReply, error)
 	mustEmbedUnimplementedServiceServer()
 }
 
@@ -83,6 +109,12 @@ func (UnimplementedServiceServer) Classes(context.Context, *ClassRequest) (*Clas
 func (UnimplementedServiceServer) Elements(context.Context, *ClassElementRequest) (*ClassElementReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Elements not implemented")
 }
+func (UnimplementedServiceServer) Lookup(context.Context, *LookupRequest) (*LookupReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedServiceServer) Translate(context.Context, *TranslateRequest) (*TranslateReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Translate not implemented")
+}
 func (UnimplementedServiceServer) mustEmbedUnimplementedServiceServer() {}
 func (UnimplementedServiceServer) testEmbeddedByValue()                 {}
 
@@ -140,6 +172,42 @@ func _Service_Elements_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Service_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_Translate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TranslateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).Translate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_Translate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).Translate(ctx, req.(*TranslateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Service_ServiceDesc is the grpc.ServiceDesc for Service service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -155,6 +223,14 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Elements",
 			Handler:    _Service_Elements_Handler,
 		},
+		{
+			MethodName: "Lookup",
+			Handler:    _Service_Lookup_Handler,
+		},
+		{
+			MethodName: "Translate",
+			Handler:    _Service_Translate_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "middleware/class.proto",
@@ -1,7 +1,7 @@
 package class
 
 func ElementStatusFromSql(key string) ClassElementStatus {
-	return ClassElementStatus(ClassStatus_value[key])
+	return ClassElementStatus(ClassElementStatus_value[key])
 }
 
 //goland:noinspection GoNameStartsWithPackageName
@@ -26,6 +26,8 @@ const (
 	Service_CreateKey_FullMethodName   = "/hasq.Service/CreateKey"
 	Service_Owned_FullMethodName       = "/hasq.Service/Owned"
 	Service_Validate_FullMethodName    = "/hasq.Service/Validate"
+	Service_AuditEvents_FullMethodName = "/hasq.Service/AuditEvents"
+	Service_VerifyAudit_FullMethodName = "/hasq.Service/VerifyAudit"
 )
 
 // ServiceClient is the client API for Service service.
@@ -37,6 +39,8 @@ type ServiceClient interface {
 	CreateKey(ctx context.Context, in *KeyCreate, opts ...grpc.CallOption) (*KeyCreateReply, error)
 	Owned(ctx context.Context, in *OwnerCreate, opts ...grpc.CallOption) (*OwnerCreateReply, error)
 	Validate(ctx context.Context, in *ChainValidate, opts ...grpc.CallOption) (*ChainValidateReply, error)
+	AuditEvents(ctx context.Context, in *AuditSearch, opts ...grpc.CallOption) (*AuditReply, error)
+	VerifyAudit(ctx context.Context, in *AuditVerify, opts ...grpc.CallOption) (*AuditVerifyReply, error)
 }
 
 type serviceClient struct {
@@ -97,6 +101,26 @@ func (c *serviceClient) Validate(ctx context.Context, in *ChainValidate, opts ..
 	return out, nil
 }
 
+func (c *serviceClient) AuditEvents(ctx context.Context, in *AuditSearch, opts ...grpc.CallOption) (*AuditReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AuditReply)
+	err := c.cc.Invoke(ctx, Service_AuditEvents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) VerifyAudit(ctx context.Context, in *AuditVerify, opts ...grpc.CallOption) (*AuditVerifyReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AuditVerifyReply)
+	err := c.cc.Invoke(ctx, Service_VerifyAudit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ServiceServer is the server API for Service service.
 // All implementations must embed UnimplementedServiceServer
 // for forward compatibility.
@@ -106,6 +130,8 @@ type ServiceServer interface {
 	CreateKey(context.Context, *KeyCreate) (*KeyCreateReply, error)
 	Owned(context.Context, *OwnerCreate) (*OwnerCreateReply, error)
 	Validate(context.Context, *ChainValidate) (*ChainValidateReply, error)
+	AuditEvents(context.Context, *AuditSearch) (*AuditReply, error)
+	VerifyAudit(context.Context, *AuditVerify) (*AuditVerifyReply, error)
 	mustEmbedUnimplementedServiceServer()
 }
 
@@ -131,6 +157,12 @@ func (UnimplementedServiceServer) Owned(context.Context, *OwnerCreate) (*OwnerCr
 func (UnimplementedServiceServer) Validate(context.Context, *ChainValidate) (*ChainValidateReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Validate not implemented")
 }
+func (UnimplementedServiceServer) AuditEvents(context.Context, *AuditSearch) (*AuditReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AuditEvents not implemented")
+}
+func (UnimplementedServiceServer) VerifyAudit(context.Context, *AuditVerify) (*AuditVerifyReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyAudit not implemented")
+}
 func (UnimplementedServiceServer) mustEmbedUnimplementedServiceServer() {}
 func (UnimplementedServiceServer) testEmbeddedByValue()                 {}
 
@@ -242,6 +274,42 @@ func _Service_Validate_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Service_AuditEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuditSearch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).AuditEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_AuditEvents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).AuditEvents(ctx, req.(*AuditSearch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_VerifyAudit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuditVerify)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).VerifyAudit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_VerifyAudit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).VerifyAudit(ctx, req.(*AuditVerify))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Service_ServiceDesc is the grpc.ServiceDesc for Service service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -269,6 +337,14 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Validate",
 			Handler:    _Service_Validate_Handler,
 		},
+		{
+			MethodName: "AuditEvents",
+			Handler:    _Service_AuditEvents_Handler,
+		},
+		{
+			MethodName: "VerifyAudit",
+			Handler:    _Service_VerifyAudit_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "middleware/hasq.proto",
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AuthNone вызовы не аутентифицируются
+	AuthNone = "none"
+	// AuthJwt JWT в заголовке authorization: Bearer
+	AuthJwt = "jwt"
+	// AuthMtls проверенный сертификат клиента TLS
+	AuthMtls = "mtls"
+
+	// RoleAdmin роль, которой разрешены вызовы от имени любого пользователя
+	RoleAdmin = "admin"
+	// RoleAuditor роль, которой доступен журнал аудита
+	RoleAuditor = "auditor"
+
+	authorizationHeader = "authorization"
+)
+
+type AuthConfig struct {
+	// Mode способы аутентификации через запятую: none, jwt, mtls
+	Mode string
+	// JwtSecret секрет подписи JWT алгоритмами HS
+	JwtSecret string
+	// JwksFile файл JWKS с ключами RSA и oct для проверки JWT
+	JwksFile string
+	// Issuer ожидаемый iss JWT, пустой не проверяется
+	Issuer string
+	// Audience ожидаемый aud JWT, пустой не проверяется
+	Audience string
+	// Roles роли методов через запятую: /class.Service/Lookup=reader,
+	// /class.Service/*=reader для всех методов сервиса
+	Roles string
+}
+
+// Modes способы аутентификации, пустой список при none
+func (c AuthConfig) Modes() []string {
+	var modes []string
+	for _, mode := range strings.Split(c.Mode, ",") {
+		if mode = strings.TrimSpace(mode); mode != "" && mode != AuthNone {
+			modes = append(modes, mode)
+		}
+	}
+	return modes
+}
+
+// methodRoles разбирает AuthConfig.Roles
+func (c AuthConfig) methodRoles() (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, item := range strings.Split(c.Roles, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		method, role, ok := strings.Cut(item, "=")
+		if !ok || !strings.HasPrefix(method, "/") || role == "" {
+			return nil, fmt.Errorf("invalid method role %q", item)
+		}
+		roles[method] = role
+	}
+	return roles, nil
+}
+
+// Identity аутентифицированный клиент вызова
+type Identity struct {
+	// Subject пользователь: sub JWT или CommonName сертификата
+	Subject string
+	// Roles роли из claim roles JWT или OrganizationalUnit сертификата
+	Roles []string
+	// Mode способ аутентификации: jwt или mtls
+	Mode string
+}
+
+func (i *Identity) HasRole(role string) bool {
+	return slices.Contains(i.Roles, role)
+}
+
+type identityKey struct{}
+
+// WithIdentity добавляет к контексту аутентифицированного клиента, например
+// для вызовов обработчиков внутри процесса
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom клиент текущего вызова. Без него аутентификация отключена.
+func IdentityFrom(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// AuthorizeUser разрешает вызов от имени пользователя userId только ему
+// самому и роли RoleAdmin. При отключенной аутентификации разрешено все.
+func AuthorizeUser(ctx context.Context, userId string) error {
+	identity, ok := IdentityFrom(ctx)
+	if !ok || identity.Subject == userId || identity.HasRole(RoleAdmin) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "user %s can't act on behalf of %s", identity.Subject, userId)
+}
+
+// RequireRole разрешает вызов клиенту с ролью role или RoleAdmin. Без
+// аутентификации у клиента нет ролей, поэтому вызов запрещен.
+func RequireRole(ctx context.Context, role string) error {
+	identity, ok := IdentityFrom(ctx)
+	if ok && (identity.HasRole(role) || identity.HasRole(RoleAdmin)) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "role %s required", role)
+}
+
+// authenticator проверяет клиентов вызовов способами из AuthConfig.Mode
+type authenticator struct {
+	jwt   *jwtVerifier
+	mtls  bool
+	roles map[string]string
+}
+
+// newAuthenticator возвращает nil, если аутентификация отключена
+func newAuthenticator(cfg AuthConfig) (*authenticator, error) {
+	modes := cfg.Modes()
+	if len(modes) == 0 {
+		return nil, nil
+	}
+	roles, err := cfg.methodRoles()
+	if err != nil {
+		return nil, err
+	}
+	a := &authenticator{roles: roles}
+	for _, mode := range modes {
+		switch mode {
+		case AuthJwt:
+			if a.jwt, err = newJwtVerifier(cfg); err != nil {
+				return nil, err
+			}
+		case AuthMtls:
+			a.mtls = true
+		default:
+			return nil, fmt.Errorf("unsupported auth mode %q", mode)
+		}
+	}
+	return a, nil
+}
+
+// identify находит клиента по JWT или сертификату. Переданный, но неверный
+// JWT отклоняется, даже если есть сертификат.
+func (a *authenticator) identify(ctx context.Context) (*Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(authorizationHeader); len(values) > 0 && a.jwt != nil {
+		scheme, token, ok := strings.Cut(values[0], " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, errors.New("bearer token expected")
+		}
+		claims, err := a.jwt.Verify(strings.TrimSpace(token))
+		if err != nil {
+			return nil, err
+		}
+		return &Identity{Subject: claims.Subject, Roles: claims.Roles, Mode: AuthJwt}, nil
+	}
+	if a.mtls {
+		if cert := peerCertificate(ctx); cert != nil && cert.Subject.CommonName != "" {
+			return &Identity{Subject: cert.Subject.CommonName, Roles: cert.Subject.OrganizationalUnit, Mode: AuthMtls}, nil
+		}
+	}
+	return nil, errors.New("credentials required")
+}
+
+// peerCertificate проверенный сертификат клиента TLS
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// authenticate добавляет к контексту клиента и проверяет роль метода.
+// Проверки grpc.health.v1 доступны без аутентификации.
+func (a *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if strings.HasPrefix(method, "/grpc.health.v1.") {
+		return ctx, nil
+	}
+	identity, err := a.identify(ctx)
+	if err != nil {
+		slog.DebugContext(ctx, "Authentication failed", slog.String("err", err.Error()))
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+	SetUserId(ctx, identity.Subject)
+	ctx = WithIdentity(ctx, identity)
+	role, ok := a.roles[method]
+	if !ok {
+		service := method[:strings.LastIndex(method, "/")]
+		role, ok = a.roles[service+"/*"]
+	}
+	if ok {
+		if err = RequireRole(ctx, role); err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+func (i *interceptors) authUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if i.auth == nil {
+		return handler(ctx, req)
+	}
+	ctx, err := i.auth.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (i *interceptors) authStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if i.auth == nil {
+		return handler(srv, stream)
+	}
+	ctx, err := i.auth.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, withContext(stream, ctx))
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+)
+
+const testSecret = "test-secret"
+
+func encodeSegment(t *testing.T, value any) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signHS256 подписывает claims секретом testSecret
+func signHS256(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	payload := encodeSegment(t, map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	payload := encodeSegment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(payload))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// writeJwks сохраняет открытый ключ key с идентификатором kid в файл JWKS
+func writeJwks(t *testing.T, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+	set := map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func claims(sub string, exp time.Duration, extra map[string]any) map[string]any {
+	c := map[string]any{"sub": sub, "exp": time.Now().Add(exp).Unix()}
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func TestJwtVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := newJwtVerifier(AuthConfig{JwtSecret: testSecret, JwksFile: writeJwks(t, key, "k1"), Audience: "pet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aud := map[string]any{"aud": []string{"pet", "other"}, "roles": []string{"reader"}}
+
+	c, err := v.Verify(signHS256(t, claims("u1", time.Hour, aud)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "u1" || len(c.Roles) != 1 || c.Roles[0] != "reader" {
+		t.Fatalf("unexpected claims %+v", c)
+	}
+	if c, err = v.Verify(signRS256(t, key, "k1", claims("u2", time.Hour, map[string]any{"aud": "pet"}))); err != nil || c.Subject != "u2" {
+		t.Fatalf("rs256 token rejected: %v", err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none := encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, claims("u1", time.Hour, aud)) + "."
+	tampered := signHS256(t, claims("u1", time.Hour, aud))
+	tampered = tampered[:len(tampered)-2] + "AA"
+	for name, token := range map[string]string{
+		"malformed":     "abc",
+		"none":          none,
+		"tampered":      tampered,
+		"foreign key":   signRS256(t, other, "k1", claims("u1", time.Hour, aud)),
+		"unknown kid":   signRS256(t, key, "k2", claims("u1", time.Hour, aud)),
+		"audience":      signHS256(t, claims("u1", time.Hour, map[string]any{"aud": "other"})),
+		"no subject":    signHS256(t, claims("", time.Hour, aud)),
+		"not yet valid": signHS256(t, claims("u1", time.Hour, map[string]any{"aud": "pet", "nbf": time.Now().Add(time.Hour).Unix()})),
+	} {
+		if _, err = v.Verify(token); !errors.Is(err, ErrJwtInvalid) {
+			t.Fatalf("%s: ErrJwtInvalid expected, got %v", name, err)
+		}
+	}
+	if _, err = v.Verify(signHS256(t, claims("u1", -time.Hour, aud))); !errors.Is(err, ErrJwtExpired) {
+		t.Fatalf("ErrJwtExpired expected, got %v", err)
+	}
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	ctx := context.Background()
+	if err := AuthorizeUser(ctx, "u1"); err != nil {
+		t.Fatalf("calls without identity are allowed when auth is off: %v", err)
+	}
+	user := WithIdentity(ctx, &Identity{Subject: "u1"})
+	if err := AuthorizeUser(user, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AuthorizeUser(user, "u2"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("PermissionDenied expected, got %v", err)
+	}
+	admin := WithIdentity(ctx, &Identity{Subject: "a1", Roles: []string{RoleAdmin}})
+	if err := AuthorizeUser(admin, "u2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	ctx := context.Background()
+	if err := RequireRole(ctx, RoleAuditor); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("calls without identity must be denied, got %v", err)
+	}
+	if err := RequireRole(WithIdentity(ctx, &Identity{Subject: "u1"}), RoleAuditor); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("PermissionDenied expected, got %v", err)
+	}
+	for _, role := range []string{RoleAuditor, RoleAdmin} {
+		if err := RequireRole(WithIdentity(ctx, &Identity{Subject: "u1", Roles: []string{role}}), RoleAuditor); err != nil {
+			t.Fatalf("role %s must be allowed: %v", role, err)
+		}
+	}
+}
+
+func TestNewGRPCServer_Auth(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig("test", 50051)
+	cfg.Auth = AuthConfig{Mode: AuthJwt, JwtSecret: testSecret}
+	server, err := NewGRPCServer(&cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	reflection.Register(server)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	client := healthpb.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Проверки здоровья доступны без аутентификации
+	if _, err = client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	reflect := func(ctx context.Context) error {
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			return err
+		}
+		err = stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+	if err = reflect(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Unauthenticated expected, got %v", err)
+	}
+	token := signHS256(t, claims("u1", time.Hour, nil))
+	if err = reflect(metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	if _, err := newAuthenticator(AuthConfig{Mode: "jwt,unknown", JwtSecret: testSecret}); err == nil {
+		t.Fatal("unknown auth mode must fail")
+	}
+	if _, err := newAuthenticator(AuthConfig{Mode: AuthJwt}); err == nil {
+		t.Fatal("jwt auth without keys must fail")
+	}
+	a, err := newAuthenticator(AuthConfig{Mode: "jwt,mtls", JwtSecret: testSecret, Roles: "/pet.Test/*=writer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	method := "/pet.Test/Call"
+	auth := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, "Bearer "+token))
+	}
+	if _, err = a.authenticate(context.Background(), method); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Unauthenticated expected, got %v", err)
+	}
+	if _, err = a.authenticate(auth("bad"), method); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Unauthenticated expected, got %v", err)
+	}
+	reader := signHS256(t, claims("u1", time.Hour, map[string]any{"roles": []string{"reader"}}))
+	if _, err = a.authenticate(auth(reader), method); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("PermissionDenied expected, got %v", err)
+	}
+	writer := signHS256(t, claims("u1", time.Hour, map[string]any{"roles": []string{"writer"}}))
+	authenticated, err := a.authenticate(auth(writer), method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity, ok := IdentityFrom(authenticated); !ok || identity.Subject != "u1" || identity.Mode != AuthJwt {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+}
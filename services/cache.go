@@ -2,15 +2,93 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// CacheRedis кеш в Redis, общий для всех экземпляров сервиса
+	CacheRedis = "redis"
+	// CacheMemory LRU кеш в памяти процесса
+	CacheMemory = "memory"
+	// CacheTiered LRU кеш в памяти процесса перед Redis
+	CacheTiered = "tiered"
 )
 
+// ErrCacheMiss возвращается Get, если значения по ключу нет в кеше
+var ErrCacheMiss = errors.New("cache miss")
+
 // Cache интерфейс для работы с кешом
 type Cache interface {
 	// Get Получения значения из кеша по ключу
 	Get(ctx context.Context, key string) (string, error)
+	// MGet получение значений по ключам, отсутствующих ключей нет в результате
+	MGet(ctx context.Context, keys ...string) (map[string]string, error)
 	// SetTtl установка значения по ключу с временем жизни expiration
 	SetTtl(ctx context.Context, key string, value string, expiration time.Duration) error
 	// Set установка значения по ключу
 	Set(ctx context.Context, key string, value string) error
+	// MSet установка значений по ключам с общим временем жизни expiration
+	MSet(ctx context.Context, values map[string]string, expiration time.Duration) error
+	// Delete удаление значений по ключам
+	Delete(ctx context.Context, keys ...string) error
+	// Tag связывает ключи с тегом для последующего удаления через InvalidateTags
+	Tag(ctx context.Context, tag string, keys ...string) error
+	// InvalidateTags удаляет все значения, связанные с тегами
+	InvalidateTags(ctx context.Context, tags ...string) error
+	// Ping проверяет доступность хранилища
+	Ping(ctx context.Context) error
+	// Close освобождает подключение к хранилищу
+	Close() error
+}
+
+// NewDefaultCache создает кеш, выбранный cfg.Cache.Backend
+func NewDefaultCache(ctx context.Context, cfg *Config) (Cache, error) {
+	switch cfg.Cache.Backend {
+	case CacheRedis:
+		return NewRedisCache(ctx, cfg)
+	case CacheMemory:
+		return NewMemoryCache(cfg.Cache.Size), nil
+	case CacheTiered:
+		return NewTieredCache(ctx, cfg)
+	}
+	return nil, fmt.Errorf("unsupported cache backend %q", cfg.Cache.Backend)
+}
+
+// loads объединяет загрузки одного ключа одного экземпляра кеша, поэтому
+// ключ группы начинается с адреса кеша
+var loads singleflight.Group
+
+// GetOrLoad возвращает значение из кеша или загружает его через load и
+// сохраняет с временем жизни ttl. Одновременные промахи по одному ключу в
+// экземпляре кеша выполняют load один раз. Ошибки кеша не прерывают загрузку.
+func GetOrLoad(ctx context.Context, c Cache, key string, ttl time.Duration,
+	load func(ctx context.Context) (string, error)) (string, error) {
+	value, err := c.Get(ctx, key)
+	if err == nil {
+		return value, nil
+	}
+	if !errors.Is(err, ErrCacheMiss) {
+		slog.WarnContext(ctx, "Cache read error", slog.String("key", key), slog.String("err", err.Error()))
+	}
+	result, err, _ := loads.Do(fmt.Sprintf("%p\x00%s", c, key), func() (any, error) {
+		// Загрузку разделяют несколько вызовов, поэтому отмена одного из них ее не прерывает
+		ctx := context.WithoutCancel(ctx)
+		value, err := load(ctx)
+		if err != nil {
+			return "", err
+		}
+		if err = c.SetTtl(ctx, key, value, ttl); err != nil {
+			slog.WarnContext(ctx, "Cache write error", slog.String("key", key), slog.String("err", err.Error()))
+		}
+		return value, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.(string), nil
 }
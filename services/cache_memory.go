@@ -0,0 +1,192 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	key     string
+	value   string
+	expires time.Time
+	// tags теги значения, из которых оно удаляется вместе с собой
+	tags map[string]struct{}
+}
+
+// memoryCache LRU кеш в памяти процесса не более чем на size значений
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]struct{}
+	now     func() time.Time
+}
+
+// NewMemoryCache создает LRU кеш в памяти процесса. При size <= 0
+// число значений не ограничено.
+func NewMemoryCache(size int) Cache {
+	return &memoryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		tags:    make(map[string]map[string]struct{}),
+		now:     time.Now,
+	}
+}
+
+func (c *memoryCache) get(key string) (string, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.remove(element)
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *memoryCache) set(key string, value string, expiration time.Duration) {
+	var expires time.Time
+	if expiration > 0 {
+		expires = c.now().Add(expiration)
+	}
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+	if c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove удаляет значение, в том числе вытесненное или устаревшее, и из
+// множеств его тегов
+func (c *memoryCache) remove(element *list.Element) {
+	entry := element.Value.(*memoryEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	for tag := range entry.tags {
+		delete(c.tags[tag], entry.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value, ok := c.get(key); ok {
+		return value, nil
+	}
+	return "", ErrCacheMiss
+}
+
+func (c *memoryCache) MGet(_ context.Context, keys ...string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if value, ok := c.get(key); ok {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+func (c *memoryCache) SetTtl(_ context.Context, key string, value string, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value, expiration)
+	return nil
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value string) error {
+	return c.SetTtl(ctx, key, value, 0)
+}
+
+func (c *memoryCache) MSet(_ context.Context, values map[string]string, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, value := range values {
+		c.set(key, value, expiration)
+	}
+	return nil
+}
+
+func (c *memoryCache) Delete(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+	}
+	return nil
+}
+
+// Tag связывает с тегом только значения, которые есть в кеше: отсутствующие
+// нечего удалять, а индекс тегов не растет ключами, которых нет.
+func (c *memoryCache) Tag(_ context.Context, tag string, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		element, ok := c.entries[key]
+		if !ok {
+			continue
+		}
+		entry := element.Value.(*memoryEntry)
+		if entry.tags == nil {
+			entry.tags = make(map[string]struct{})
+		}
+		entry.tags[tag] = struct{}{}
+		tagged, ok := c.tags[tag]
+		if !ok {
+			tagged = make(map[string]struct{}, len(keys))
+			c.tags[tag] = tagged
+		}
+		tagged[key] = struct{}{}
+	}
+	return nil
+}
+
+func (c *memoryCache) InvalidateTags(_ context.Context, tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			if element, ok := c.entries[key]; ok {
+				c.remove(element)
+			}
+		}
+		delete(c.tags, tag)
+	}
+	return nil
+}
+
+func (c *memoryCache) Ping(context.Context) error {
+	return nil
+}
+
+// clear удаляет все значения и теги
+func (c *memoryCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.tags = make(map[string]map[string]struct{})
+}
+
+func (c *memoryCache) Close() error {
+	c.clear()
+	return nil
+}
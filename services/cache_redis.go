@@ -22,27 +22,130 @@ func (c *redisCache) SetTtl(ctx context.Context, key string, value string, ttl t
 	return c.c.Set(ctx, key, value, ttl).Err()
 }
 
+func (c *redisCache) Ping(ctx context.Context) error {
+	return c.c.Ping(ctx).Err()
+}
+
+func (c *redisCache) Close() error {
+	return c.c.Close()
+}
+
 func (c *redisCache) Get(ctx context.Context, key string) (string, error) {
-	return c.c.Get(ctx, key).Result()
+	value, err := c.c.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrCacheMiss
+	}
+	return value, err
 }
 
-func NewRedisCache(ctx context.Context) (Cache, error) {
-	options, err := redis.ParseURL(RedisUrl)
+func (c *redisCache) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	result := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+	values, err := c.c.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if s, ok := value.(string); ok {
+			result[keys[i]] = s
+		}
+	}
+	return result, nil
+}
+
+func (c *redisCache) MSet(ctx context.Context, values map[string]string, expiration time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+	_, err := c.c.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, key, value, expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.c.Del(ctx, keys...).Err()
+}
+
+// tagTtl время жизни множества ключей тега после последнего Tag,
+// чтобы теги без инвалидации не копились в Redis
+const tagTtl = 24 * time.Hour
+
+// tagKey множество ключей, связанных с тегом
+func tagKey(tag string) string {
+	return "tag:" + tag
+}
+
+func (c *redisCache) Tag(ctx context.Context, tag string, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	members := make([]any, len(keys))
+	for i, key := range keys {
+		members[i] = key
+	}
+	_, err := c.c.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, tagKey(tag), members...)
+		pipe.Expire(ctx, tagKey(tag), tagTtl)
+		return nil
+	})
+	return err
+}
+
+func (c *redisCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		keys, err := c.c.SMembers(ctx, tagKey(tag)).Result()
+		if err != nil {
+			return err
+		}
+		if err = c.c.Del(ctx, append(keys, tagKey(tag))...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewRedisCache(ctx context.Context, cfg *Config) (Cache, error) {
+	client, err := newRedisClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCache{client}, nil
+}
+
+// RedisClient клиент Redis по cfg.Redis.Url с трассировкой и метриками.
+// В отличие от кеша он не проверяет подключение, оно устанавливается при
+// первом вызове.
+func RedisClient(cfg *Config) (*redis.Client, error) {
+	options, err := redis.ParseURL(cfg.Redis.Url)
 	if err != nil {
-		slog.Error("Failed parse URL", slog.String("error", err.Error()))
 		return nil, err
 	}
 	client := redis.NewClient(options)
 	if err = errors.Join(redisotel.InstrumentTracing(client), redisotel.InstrumentMetrics(client)); err != nil {
 		slog.Error("Can't create instrument tracing", slog.String("error", err.Error()))
 	}
+	return client, nil
+}
+
+func newRedisClient(ctx context.Context, cfg *Config) (*redis.Client, error) {
+	client, err := RedisClient(cfg)
+	if err != nil {
+		slog.Error("Failed parse URL", slog.String("error", err.Error()))
+		return nil, err
+	}
 	if err = client.Ping(ctx).Err(); err != nil {
 		slog.Error("Failed to connect to redis server", slog.String("error", err.Error()))
+		_ = client.Close()
 		return nil, err
 	}
-	return &redisCache{client}, nil
-}
-
-func NewDefaultCache(ctx context.Context) (Cache, error) {
-	return NewRedisCache(ctx)
+	return client, nil
 }
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// testCacheContract проверяет поведение, общее для всех реализаций Cache
+func testCacheContract(t *testing.T, c Cache) {
+	ctx := context.Background()
+	if _, err := c.Get(ctx, "test:missing"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("cache miss expected, got %v", err)
+	}
+	err := c.MSet(ctx, map[string]string{"test:a": "1", "test:b": "2"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := c.MGet(ctx, "test:a", "test:b", "test:missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["test:a"] != "1" || values["test:b"] != "2" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if err = c.Delete(ctx, "test:a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Get(ctx, "test:a"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("deleted value returned: %v", err)
+	}
+
+	if err = c.Set(ctx, "test:c", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Tag(ctx, "test:tag", "test:b", "test:c"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.InvalidateTags(ctx, "test:tag"); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ = c.MGet(ctx, "test:b", "test:c"); len(values) != 0 {
+		t.Fatalf("tagged values must be invalidated, got %v", values)
+	}
+}
+
+func TestMemoryCache_Contract(t *testing.T) {
+	testCacheContract(t, NewMemoryCache(10))
+}
+
+func TestRedisCache_Contract(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL is not set")
+	}
+	cfg := DefaultConfig("test", 50051)
+	cfg.Redis.Url = url
+	c, err := NewRedisCache(context.Background(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	testCacheContract(t, c)
+}
+
+func TestMemoryCache_Eviction(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(2)
+	_ = c.Set(ctx, "a", "1")
+	_ = c.Set(ctx, "b", "2")
+	_, _ = c.Get(ctx, "a")
+	_ = c.Set(ctx, "c", "3")
+	if _, err := c.Get(ctx, "b"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatal("least recently used value must be evicted")
+	}
+	if values, _ := c.MGet(ctx, "a", "c"); len(values) != 2 {
+		t.Fatalf("recent values expected, got %v", values)
+	}
+}
+
+func TestMemoryCache_Expiration(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(0).(*memoryCache)
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return clock }
+	_ = c.SetTtl(ctx, "a", "1", time.Minute)
+	clock = clock.Add(59 * time.Second)
+	if _, err := c.Get(ctx, "a"); err != nil {
+		t.Fatal("value expected before expiration")
+	}
+	clock = clock.Add(time.Second)
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatal("expired value returned")
+	}
+}
+
+func TestMemoryCache_TagsCleanup(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(1).(*memoryCache)
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return clock }
+	_ = c.Set(ctx, "a", "1")
+	_ = c.Tag(ctx, "tag", "a", "missing")
+	_ = c.SetTtl(ctx, "b", "2", time.Minute)
+	_ = c.Tag(ctx, "tag", "b")
+	if tagged := c.tags["tag"]; len(tagged) != 1 {
+		t.Fatalf("evicted and missing keys must not stay tagged, got %v", tagged)
+	}
+	clock = clock.Add(time.Minute)
+	_, _ = c.Get(ctx, "b")
+	if len(c.tags) != 0 {
+		t.Fatalf("expired key must not stay tagged, got %v", c.tags)
+	}
+}
+
+func TestGetOrLoad_SingleFlight(t *testing.T) {
+	c := NewMemoryCache(0)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	load := func(context.Context) (string, error) {
+		calls.Add(1)
+		<-release
+		return "loaded", nil
+	}
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = GetOrLoad(context.Background(), c, "key", time.Minute, load)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("one load expected, got %d", calls.Load())
+	}
+	for _, result := range results {
+		if result != "loaded" {
+			t.Fatalf("unexpected result %q", result)
+		}
+	}
+	if value, _ := c.Get(context.Background(), "key"); value != "loaded" {
+		t.Fatal("loaded value must be cached")
+	}
+}
+
+func TestGetOrLoad_SeparateCaches(t *testing.T) {
+	first, second := NewMemoryCache(0), NewMemoryCache(0)
+	release := make(chan struct{})
+	load := func(value string) func(context.Context) (string, error) {
+		return func(context.Context) (string, error) {
+			<-release
+			return value, nil
+		}
+	}
+	var wg sync.WaitGroup
+	results := make([]string, 2)
+	for i, c := range []Cache{first, second} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = GetOrLoad(context.Background(), c, "key", time.Minute, load(strconv.Itoa(i)))
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if results[0] != "0" || results[1] != "1" {
+		t.Fatalf("each cache must load its own value, got %v", results)
+	}
+	if value, _ := second.Get(context.Background(), "key"); value != "1" {
+		t.Fatalf("second cache must be written, got %q", value)
+	}
+}
+
+func TestTypedCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(0)
+	type point struct {
+		X, Y int
+	}
+	points := NewJSONCache[point](c, time.Minute)
+	loaded, err := points.GetOrLoad(ctx, "point", func(context.Context) (point, error) {
+		return point{X: 1, Y: 2}, nil
+	})
+	if err != nil || loaded != (point{X: 1, Y: 2}) {
+		t.Fatalf("unexpected point %v, %v", loaded, err)
+	}
+	if cached, err := points.Get(ctx, "point"); err != nil || cached != loaded {
+		t.Fatalf("cached point expected, got %v, %v", cached, err)
+	}
+
+	messages := NewProtoCache[wrapperspb.StringValue](c, time.Minute)
+	if err = messages.Set(ctx, "message", wrapperspb.String("value")); err != nil {
+		t.Fatal(err)
+	}
+	message, err := messages.Get(ctx, "message")
+	if err != nil || message.GetValue() != "value" {
+		t.Fatalf("unexpected message %v, %v", message, err)
+	}
+
+	_ = c.Set(ctx, "broken", "{")
+	repaired, err := points.GetOrLoad(ctx, "broken", func(context.Context) (point, error) {
+		return point{X: 3}, nil
+	})
+	if err != nil || repaired.X != 3 {
+		t.Fatalf("broken value must be reloaded, got %v, %v", repaired, err)
+	}
+}
+
+// memoryBus рассылка сообщений внутри процесса для проверки нескольких экземпляров
+type memoryBus struct {
+	mu       sync.Mutex
+	handlers map[int]func([]byte)
+	next     int
+}
+
+func (b *memoryBus) Publish(_ context.Context, message []byte) error {
+	b.mu.Lock()
+	handlers := make([]func([]byte), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.Unlock()
+	for _, handler := range handlers {
+		handler(message)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(ctx context.Context, handler func([]byte)) {
+	b.mu.Lock()
+	id := b.next
+	b.next++
+	b.handlers[id] = handler
+	b.mu.Unlock()
+	<-ctx.Done()
+	b.mu.Lock()
+	delete(b.handlers, id)
+	b.mu.Unlock()
+}
+
+func (b *memoryBus) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.handlers)
+}
+
+func (b *memoryBus) Close() error {
+	return nil
+}
+
+func newTestTiers(t *testing.T, replicas int) []*tieredCache {
+	remote := NewMemoryCache(0)
+	bus := &memoryBus{handlers: make(map[int]func([]byte))}
+	tiers := make([]*tieredCache, replicas)
+	for i := range tiers {
+		tiers[i] = newTieredCache(NewMemoryCache(10).(*memoryCache), remote, bus, time.Minute)
+		t.Cleanup(func() { _ = tiers[i].Close() })
+	}
+	for bus.subscribers() < replicas {
+		time.Sleep(time.Millisecond)
+	}
+	return tiers
+}
+
+func TestTieredCache_Contract(t *testing.T) {
+	testCacheContract(t, newTestTiers(t, 1)[0])
+}
+
+func TestTieredCache_Invalidation(t *testing.T) {
+	ctx := context.Background()
+	tiers := newTestTiers(t, 2)
+	a, b := tiers[0], tiers[1]
+	if err := a.Set(ctx, "key", "1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if value, err := b.Get(ctx, "key"); err != nil || value != "1" {
+			t.Fatalf("unexpected value %q, %v", value, err)
+		}
+	}
+	if hits := b.stats[tierLocal].hits.Load(); hits != 1 {
+		t.Fatalf("second read must be served locally, got %d local hits", hits)
+	}
+	if ratio := b.stats[tierRemote].ratio(); ratio != 1 {
+		t.Fatalf("remote hit ratio 1 expected, got %g", ratio)
+	}
+
+	if err := a.Set(ctx, "key", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := b.Get(ctx, "key"); value != "2" {
+		t.Fatalf("stale local value %q returned", value)
+	}
+
+	_ = a.Tag(ctx, "tag", "key")
+	_ = a.InvalidateTags(ctx, "tag")
+	if _, err := b.Get(ctx, "key"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("value of invalidated tag returned: %v", err)
+	}
+}
+
+func TestTieredCache_InvalidationByOrigin(t *testing.T) {
+	ctx := context.Background()
+	tiers := newTestTiers(t, 2)
+	a, b := tiers[0], tiers[1]
+	if err := a.Set(ctx, "key", "1"); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Tag(ctx, "tag", "key")
+	// Значение попадает в локальный уровень b через Get без тега
+	if value, err := b.Get(ctx, "key"); err != nil || value != "1" {
+		t.Fatalf("unexpected value %q, %v", value, err)
+	}
+	if err := b.InvalidateTags(ctx, "tag"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(ctx, "key"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("value of invalidated tag returned by the origin: %v", err)
+	}
+}
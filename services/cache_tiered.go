@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// invalidationChannel канал Redis, в котором экземпляры сервисов сообщают
+// друг другу об изменении ключей
+const invalidationChannel = "cache:invalidate"
+
+// invalidation сообщение об изменении ключей и тегов экземпляром origin
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	// Flush сбросить локальный уровень целиком
+	Flush bool `json:"flush,omitempty"`
+}
+
+// invalidationBus доставляет сообщения об изменениях всем экземплярам
+type invalidationBus interface {
+	Publish(ctx context.Context, message []byte) error
+	// Subscribe вызывает handler для каждого сообщения до отмены ctx
+	Subscribe(ctx context.Context, handler func(message []byte))
+	Close() error
+}
+
+// tierStats счетчики попаданий одного уровня кеша
+type tierStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func (s *tierStats) ratio() float64 {
+	hits, misses := s.hits.Load(), s.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// tieredCache двухуровневый кеш: ограниченный LRU в памяти процесса перед
+// общим кешем. Изменения ключей рассылаются через bus, остальные экземпляры
+// удаляют их из своего LRU. Сообщения могут теряться при переподключении,
+// поэтому локальные значения живут не дольше localTtl.
+type tieredCache struct {
+	id       string
+	local    *memoryCache
+	remote   Cache
+	bus      invalidationBus
+	localTtl time.Duration
+	stats    map[string]*tierStats
+	metrics  metric.Registration
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+const (
+	tierLocal  = "local"
+	tierRemote = "remote"
+)
+
+// NewTieredCache создает кеш из LRU на cfg.Cache.Size значений перед Redis
+// с рассылкой изменений через pub/sub Redis
+func NewTieredCache(ctx context.Context, cfg *Config) (Cache, error) {
+	client, err := newRedisClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newTieredCache(NewMemoryCache(cfg.Cache.Size).(*memoryCache), &redisCache{client}, &redisBus{client},
+		cfg.Cache.LocalTtl), nil
+}
+
+func newTieredCache(local *memoryCache, remote Cache, bus invalidationBus, localTtl time.Duration) *tieredCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &tieredCache{
+		id:       uuid.NewString(),
+		local:    local,
+		remote:   remote,
+		bus:      bus,
+		localTtl: localTtl,
+		stats:    map[string]*tierStats{tierLocal: {}, tierRemote: {}},
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	c.instrument()
+	go func() {
+		defer close(c.done)
+		bus.Subscribe(ctx, c.receive)
+	}()
+	return c
+}
+
+func (c *tieredCache) instrument() {
+	meter := otel.Meter("pet/services")
+	hits, err := meter.Int64ObservableCounter("cache.tier.hits",
+		metric.WithDescription("Number of cache hits per tier"))
+	if err != nil {
+		slog.Error("Can't create cache tier hits counter", slog.String("err", err.Error()))
+		return
+	}
+	misses, err := meter.Int64ObservableCounter("cache.tier.misses",
+		metric.WithDescription("Number of cache misses per tier"))
+	if err != nil {
+		slog.Error("Can't create cache tier misses counter", slog.String("err", err.Error()))
+		return
+	}
+	ratio, err := meter.Float64ObservableGauge("cache.tier.hit_ratio",
+		metric.WithDescription("Share of cache reads served by the tier"))
+	if err != nil {
+		slog.Error("Can't create cache tier ratio gauge", slog.String("err", err.Error()))
+		return
+	}
+	c.metrics, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for tier, stats := range c.stats {
+			attrs := metric.WithAttributes(attribute.String("tier", tier))
+			o.ObserveInt64(hits, stats.hits.Load(), attrs)
+			o.ObserveInt64(misses, stats.misses.Load(), attrs)
+			o.ObserveFloat64(ratio, stats.ratio(), attrs)
+		}
+		return nil
+	}, hits, misses, ratio)
+	if err != nil {
+		slog.Error("Can't register cache tier metrics", slog.String("err", err.Error()))
+	}
+}
+
+func (c *tieredCache) count(tier string, hit bool) {
+	if hit {
+		c.stats[tier].hits.Add(1)
+	} else {
+		c.stats[tier].misses.Add(1)
+	}
+}
+
+// ttl время жизни значения в локальном уровне
+func (c *tieredCache) ttl(expiration time.Duration) time.Duration {
+	if expiration <= 0 || expiration > c.localTtl {
+		return c.localTtl
+	}
+	return expiration
+}
+
+func (c *tieredCache) receive(message []byte) {
+	var m invalidation
+	if err := json.Unmarshal(message, &m); err != nil {
+		slog.Warn("Invalid cache invalidation message", slog.String("err", err.Error()))
+		return
+	}
+	if m.Origin == c.id {
+		return
+	}
+	if m.Flush {
+		c.local.clear()
+		return
+	}
+	_ = c.local.Delete(context.Background(), m.Keys...)
+}
+
+func (c *tieredCache) publish(ctx context.Context, m invalidation) {
+	m.Origin = c.id
+	message, err := json.Marshal(m)
+	if err == nil {
+		err = c.bus.Publish(ctx, message)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Can't publish cache invalidation", slog.String("err", err.Error()))
+	}
+}
+
+func (c *tieredCache) Get(ctx context.Context, key string) (string, error) {
+	if value, err := c.local.Get(ctx, key); err == nil {
+		c.count(tierLocal, true)
+		return value, nil
+	}
+	c.count(tierLocal, false)
+	value, err := c.remote.Get(ctx, key)
+	c.count(tierRemote, err == nil)
+	if err != nil {
+		return "", err
+	}
+	_ = c.local.SetTtl(ctx, key, value, c.localTtl)
+	return value, nil
+}
+
+func (c *tieredCache) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	result, _ := c.local.MGet(ctx, keys...)
+	if result == nil {
+		result = make(map[string]string, len(keys))
+	}
+	var missing []string
+	for _, key := range keys {
+		_, ok := result[key]
+		c.count(tierLocal, ok)
+		if !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+	values, err := c.remote.MGet(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range missing {
+		value, ok := values[key]
+		c.count(tierRemote, ok)
+		if ok {
+			result[key] = value
+		}
+	}
+	_ = c.local.MSet(ctx, values, c.localTtl)
+	return result, nil
+}
+
+func (c *tieredCache) SetTtl(ctx context.Context, key string, value string, expiration time.Duration) error {
+	if err := c.remote.SetTtl(ctx, key, value, expiration); err != nil {
+		return err
+	}
+	_ = c.local.SetTtl(ctx, key, value, c.ttl(expiration))
+	c.publish(ctx, invalidation{Keys: []string{key}})
+	return nil
+}
+
+func (c *tieredCache) Set(ctx context.Context, key string, value string) error {
+	return c.SetTtl(ctx, key, value, 0)
+}
+
+func (c *tieredCache) MSet(ctx context.Context, values map[string]string, expiration time.Duration) error {
+	if err := c.remote.MSet(ctx, values, expiration); err != nil {
+		return err
+	}
+	_ = c.local.MSet(ctx, values, c.ttl(expiration))
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	c.publish(ctx, invalidation{Keys: keys})
+	return nil
+}
+
+func (c *tieredCache) Delete(ctx context.Context, keys ...string) error {
+	if err := c.remote.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	_ = c.local.Delete(ctx, keys...)
+	c.publish(ctx, invalidation{Keys: keys})
+	return nil
+}
+
+func (c *tieredCache) Tag(ctx context.Context, tag string, keys ...string) error {
+	if err := c.remote.Tag(ctx, tag, keys...); err != nil {
+		return err
+	}
+	return c.local.Tag(ctx, tag, keys...)
+}
+
+func (c *tieredCache) InvalidateTags(ctx context.Context, tags ...string) error {
+	if err := c.remote.InvalidateTags(ctx, tags...); err != nil {
+		return err
+	}
+	// Значения тега могли попасть в локальный уровень через Get без самого
+	// тега, поэтому все экземпляры, включая этот, сбрасывают его целиком
+	c.local.clear()
+	c.publish(ctx, invalidation{Tags: tags, Flush: true})
+	return nil
+}
+
+func (c *tieredCache) Ping(ctx context.Context) error {
+	return c.remote.Ping(ctx)
+}
+
+func (c *tieredCache) Close() error {
+	c.cancel()
+	<-c.done
+	if c.metrics != nil {
+		_ = c.metrics.Unregister()
+	}
+	_ = c.local.Close()
+	return c.bus.Close()
+}
+
+// redisBus рассылка сообщений через pub/sub Redis. Клиент общий с
+// удаленным уровнем кеша и закрывается вместе с ним.
+type redisBus struct {
+	c *redis.Client
+}
+
+func (b *redisBus) Publish(ctx context.Context, message []byte) error {
+	return b.c.Publish(ctx, invalidationChannel, message).Err()
+}
+
+func (b *redisBus) Subscribe(ctx context.Context, handler func(message []byte)) {
+	pubsub := b.c.Subscribe(ctx, invalidationChannel)
+	defer func() { _ = pubsub.Close() }()
+	for {
+		received, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("Cache invalidation subscription error", slog.String("err", err.Error()))
+			// После переподключения сообщения могли быть потеряны
+			handler(flushMessage)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if m, ok := received.(*redis.Message); ok {
+			handler([]byte(m.Payload))
+		}
+	}
+}
+
+func (b *redisBus) Close() error {
+	return b.c.Close()
+}
+
+var flushMessage, _ = json.Marshal(invalidation{Flush: true})
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec преобразует значения типа T в строки кеша и обратно
+type Codec[T any] interface {
+	Encode(value T) (string, error)
+	Decode(data string) (T, error)
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(value T) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+func (jsonCodec[T]) Decode(data string) (T, error) {
+	var value T
+	err := json.Unmarshal([]byte(data), &value)
+	return value, err
+}
+
+type protoCodec[T any, P interface {
+	*T
+	proto.Message
+}] struct{}
+
+func (protoCodec[T, P]) Encode(value P) (string, error) {
+	data, err := proto.Marshal(value)
+	return string(data), err
+}
+
+func (protoCodec[T, P]) Decode(data string) (P, error) {
+	value := P(new(T))
+	err := proto.Unmarshal([]byte(data), value)
+	return value, err
+}
+
+// TypedCache кеш значений типа T поверх строкового Cache
+type TypedCache[T any] struct {
+	cache Cache
+	codec Codec[T]
+	ttl   time.Duration
+}
+
+// NewTypedCache создает кеш значений, преобразуемых codec, с временем жизни ttl
+func NewTypedCache[T any](cache Cache, codec Codec[T], ttl time.Duration) *TypedCache[T] {
+	return &TypedCache[T]{cache: cache, codec: codec, ttl: ttl}
+}
+
+// NewJSONCache создает кеш значений, хранимых в JSON
+func NewJSONCache[T any](cache Cache, ttl time.Duration) *TypedCache[T] {
+	return NewTypedCache[T](cache, jsonCodec[T]{}, ttl)
+}
+
+// NewProtoCache создает кеш сообщений protobuf типа *T
+func NewProtoCache[T any, P interface {
+	*T
+	proto.Message
+}](cache Cache, ttl time.Duration) *TypedCache[P] {
+	return NewTypedCache[P](cache, protoCodec[T, P]{}, ttl)
+}
+
+// Get возвращает значение по ключу или ErrCacheMiss
+func (c *TypedCache[T]) Get(ctx context.Context, key string) (T, error) {
+	data, err := c.cache.Get(ctx, key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return c.codec.Decode(data)
+}
+
+// Set сохраняет значение по ключу
+func (c *TypedCache[T]) Set(ctx context.Context, key string, value T) error {
+	data, err := c.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	return c.cache.SetTtl(ctx, key, data, c.ttl)
+}
+
+// GetOrLoad возвращает значение из кеша или загружает его через load, как GetOrLoad
+func (c *TypedCache[T]) GetOrLoad(ctx context.Context, key string, load func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	data, err := GetOrLoad(ctx, c.cache, key, c.ttl, func(ctx context.Context) (string, error) {
+		value, err := load(ctx)
+		if err != nil {
+			return "", err
+		}
+		return c.codec.Encode(value)
+	})
+	if err != nil {
+		return zero, err
+	}
+	value, err := c.codec.Decode(data)
+	if err == nil {
+		return value, nil
+	}
+	// Испорченное значение заменяем заново загруженным
+	if value, err = load(ctx); err != nil {
+		return zero, err
+	}
+	return value, c.Set(ctx, key, value)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"pet/middleware/class"
+	"pet/services"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/protobuf/proto"
+)
+
+const cacheKeyPrefix = "class"
+
+// classCache кеширует ответы Classes и Elements в services.Cache.
+// Ключи содержат поколение изменений из class_generation, поэтому
+// любое зафиксированное изменение делает прежние записи недостижимыми.
+type classCache struct {
+	cache   services.Cache
+	changes *changeLog
+	ttl     time.Duration
+	hits    metric.Int64Counter
+	misses  metric.Int64Counter
+}
+
+func newClassCache(cache services.Cache, changes *changeLog, ttl time.Duration) *classCache {
+	meter := otel.Meter("pet/services/cmd/class")
+	hits, err := meter.Int64Counter("class.cache.hits",
+		metric.WithDescription("Number of class replies served from cache"))
+	if err != nil {
+		slog.Error("Can't create cache hits counter", slog.String("err", err.Error()))
+	}
+	misses, err := meter.Int64Counter("class.cache.misses",
+		metric.WithDescription("Number of class replies loaded from database"))
+	if err != nil {
+		slog.Error("Can't create cache misses counter", slog.String("err", err.Error()))
+	}
+	return &classCache{cache: cache, changes: changes, ttl: ttl, hits: hits, misses: misses}
+}
+
+// enabled кеш работает, только если есть хранилище и известно текущее поколение
+func (c *classCache) enabled() bool {
+	return c != nil && c.cache != nil && c.changes.current() >= 0
+}
+
+func (c *classCache) key(method string, parts ...any) string {
+	var b strings.Builder
+	b.WriteString(cacheKeyPrefix)
+	b.WriteString(":")
+	b.WriteString(fmt.Sprint(c.changes.current()))
+	b.WriteString(":")
+	b.WriteString(method)
+	for _, part := range parts {
+		b.WriteString(":")
+		b.WriteString(optional(part))
+	}
+	return b.String()
+}
+
+func (c *classCache) classesKey(request *class.ClassRequest) string {
+	return c.key("classes", request.NameFilter, request.Status, request.Version)
+}
+
+func (c *classCache) elementsKey(request *class.ClassElementRequest) string {
+	return c.key("elements", request.Name, request.Version, request.Status, request.Subtree,
+		request.PageToken, request.Limit)
+}
+
+// load читает ответ из кеша, возвращает false при промахе или ошибке
+func (c *classCache) load(ctx context.Context, method, key string, reply proto.Message) bool {
+	if !c.enabled() {
+		return false
+	}
+	value, err := c.cache.Get(ctx, key)
+	if err == nil {
+		err = proto.Unmarshal([]byte(value), reply)
+	}
+	attributes := metric.WithAttributes(attribute.String("method", method))
+	if err != nil {
+		if !errors.Is(err, services.ErrCacheMiss) {
+			slog.WarnContext(ctx, "Class cache read error", slog.String("key", key), slog.String("err", err.Error()))
+		}
+		c.misses.Add(ctx, 1, attributes)
+		return false
+	}
+	c.hits.Add(ctx, 1, attributes)
+	return true
+}
+
+func (c *classCache) store(ctx context.Context, key string, reply proto.Message) {
+	if !c.enabled() {
+		return
+	}
+	data, err := proto.Marshal(reply)
+	if err == nil {
+		err = c.cache.SetTtl(ctx, key, string(data), c.ttl)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Class cache write error", slog.String("key", key), slog.String("err", err.Error()))
+	}
+}
+
+// optional приводит необязательные поля запроса к части ключа
+func optional(value any) string {
+	switch v := value.(type) {
+	case *string:
+		if v != nil {
+			return fmt.Sprintf("%q", *v)
+		}
+	case *uint32:
+		if v != nil {
+			return fmt.Sprint(*v)
+		}
+	case *class.ClassStatus:
+		if v != nil {
+			return v.String()
+		}
+	case *class.ClassElementStatus:
+		if v != nil {
+			return v.String()
+		}
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return "-"
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pet/middleware/class"
+	"pet/services"
+)
+
+type countingDatabase struct {
+	DatabaseClass
+	generation int64
+	classes    int
+	elements   int
+}
+
+func (d *countingDatabase) Classes(context.Context, *string, *string, *uint32) ([]Class, error) {
+	d.classes++
+	return []Class{{Name: "sex", Title: "Пол человека", Status: "CLASS_PUBLISHED"}}, nil
+}
+
+func (d *countingDatabase) Class(_ context.Context, name string) (*Class, error) {
+	return &Class{Name: name, TableName: classTablePrefix + name}, nil
+}
+
+func (d *countingDatabase) Elements(context.Context, Class, *uint32, *string, *string, int64, int) ([]Element, bool, error) {
+	d.elements++
+	return []Element{{Next: 1, Key: "m", Value: "мужской", Version: 1, Status: "ITEM_PUBLISHED"}}, false, nil
+}
+
+func (d *countingDatabase) Generation(context.Context) (int64, error) {
+	return d.generation, nil
+}
+
+func TestService_ClassesCached(t *testing.T) {
+	db := &countingDatabase{generation: 1}
+	changes := newChangeLog(db)
+	changes.refresh(context.Background())
+	cache := newClassCache(services.NewMemoryCache(0), changes, time.Minute)
+	s := &service{db: db, cache: cache}
+	for i := 0; i < 3; i++ {
+		reply, err := s.Classes(context.Background(), &class.ClassRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reply.Classes) != 1 || reply.Classes[0].Name != "sex" {
+			t.Fatalf("unexpected reply %v", reply)
+		}
+	}
+	if db.classes != 1 {
+		t.Fatalf("expected one database read, got %d", db.classes)
+	}
+	db.generation++
+	changes.refresh(context.Background())
+	if _, err := s.Classes(context.Background(), &class.ClassRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if db.classes != 2 {
+		t.Fatalf("expected read after generation change, got %d", db.classes)
+	}
+}
+
+func TestService_ElementsCachedPerPage(t *testing.T) {
+	db := &countingDatabase{generation: 7}
+	changes := newChangeLog(db)
+	changes.refresh(context.Background())
+	cache := newClassCache(services.NewMemoryCache(0), changes, time.Minute)
+	s := &service{db: db, cache: cache}
+	published := class.ClassElementStatus_ITEM_PUBLISHED
+	status := "ITEM_PUBLISHED"
+	token := pageToken{After: 10, Filter: pageFilter("sex", nil, &status, nil)}.String()
+	requests := []*class.ClassElementRequest{
+		{Name: "sex"},
+		{Name: "sex", Status: &published},
+		{Name: "sex", Status: &published, PageToken: &token},
+	}
+	for round := 0; round < 2; round++ {
+		for _, request := range requests {
+			if _, err := s.Elements(context.Background(), request); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if db.elements != len(requests) {
+		t.Fatalf("expected %d database reads, got %d", len(requests), db.elements)
+	}
+}
+
+func TestService_CacheDisabled(t *testing.T) {
+	db := &countingDatabase{}
+	s := &service{db: db, cache: newClassCache(nil, newChangeLog(db), time.Minute)}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Classes(context.Background(), &class.ClassRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.classes != 2 {
+		t.Fatalf("expected database reads without cache, got %d", db.classes)
+	}
+}
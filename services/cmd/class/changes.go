@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// changeLog отслеживает поколение изменений классов и их значений по счетчику
+// class_generation. Отрицательное поколение означает, что оно неизвестно.
+type changeLog struct {
+	db         DatabaseClass
+	generation atomic.Int64
+}
+
+func newChangeLog(db DatabaseClass) *changeLog {
+	l := &changeLog{db: db}
+	l.generation.Store(-1)
+	return l
+}
+
+func (l *changeLog) current() int64 {
+	return l.generation.Load()
+}
+
+// refresh перечитывает поколение изменений, при ошибке поколение становится неизвестным
+func (l *changeLog) refresh(ctx context.Context) {
+	generation, err := l.db.Generation(ctx)
+	if err != nil {
+		slog.Error("Can't read class changes generation", slog.String("err", err.Error()))
+		l.generation.Store(-1)
+		return
+	}
+	if previous := l.generation.Swap(generation); previous != generation {
+		slog.Debug("Class changes generation changed",
+			slog.Int64("from", previous), slog.Int64("to", generation))
+	}
+}
+
+// watch опрашивает журнал изменений с интервалом interval до отмены ctx
+func (l *changeLog) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.refresh(ctx)
+		}
+	}
+}
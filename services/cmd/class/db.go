@@ -1,20 +1,30 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"embed"
+	"errors"
 	"fmt"
+	"log/slog"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 
 	"pet/services"
-
-	_ "github.com/lib/pq"
+	"pet/services/migrations"
 )
 
 const (
+	classTablePrefix = "class_"
+	// maxIdentifierLength ограничение PostgreSQL на длину идентификатора (NAMEDATALEN - 1)
+	maxIdentifierLength = 63
+	// maxClassNameLength оставляет место под префикс таблицы и суффиксы триггеров
+	maxClassNameLength = maxIdentifierLength - len(classTablePrefix) - len("_after_update_status")
+
 	sqlCreateClassTable = `
 CREATE TABLE %s
 (
@@ -28,41 +38,68 @@ CREATE TABLE %s
     after_at   TIMESTAMP                                                             DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP NOT NULL                                                    DEFAULT CURRENT_TIMESTAMP,
     created_at TIMESTAMP NOT NULL                                                    DEFAULT CURRENT_TIMESTAMP,
+    parent_key VARCHAR                                                               DEFAULT NULL,
     UNIQUE (key, value, version)
 )`
 	sqlCreateAfterInsertTrigger = `
-CREATE TRIGGER %s_after_insert
+CREATE TRIGGER %s
     AFTER INSERT
     ON %s
     FOR EACH ROW
-EXECUTE FUNCTION fn_change_value_after_insert('%s');
+EXECUTE FUNCTION fn_change_value_after_insert(%s);
 `
 	sqlCreateChangeStatusTrigger = `
-CREATE TRIGGER %s_after_update_status
+CREATE TRIGGER %s
     AFTER UPDATE
     ON %s
     FOR EACH ROW
     WHEN (NEW.status != OLD.status)
-EXECUTE FUNCTION fn_change_value_after_update_status('%s')
+EXECUTE FUNCTION fn_change_value_after_update_status(%s)
 `
 	sqlCreateAfterUpdateTrigger = `
-CREATE TRIGGER %s_after_update_after
+CREATE TRIGGER %s
     AFTER UPDATE
     ON %s
     FOR EACH ROW
     WHEN (NEW.after_at != OLD.after_at)
-EXECUTE FUNCTION fn_change_value_after_update_after('%s')
+EXECUTE FUNCTION fn_change_value_after_update_after(%s)
+`
+	sqlCreateCheckParentTrigger = `
+CREATE TRIGGER %s
+    BEFORE INSERT OR UPDATE OF parent_key, version
+    ON %s
+    FOR EACH ROW
+    WHEN (NEW.parent_key IS NOT NULL)
+EXECUTE FUNCTION fn_check_parent_key(%s)
 `
+	sqlSelectClass = `
+SELECT c.id, c.name, c.title, c.table_name, c.current, c.status, c.updated_at, p.name
+FROM classes c
+         LEFT JOIN classes p ON p.id = c.parent_id
+WHERE 1 = 1`
 )
 
-//go:embed migrations/*.sql
-var migrations embed.FS
+var (
+	ErrInvalidClassName = errors.New("invalid class name")
+	ErrClassNotFound    = errors.New("class not found")
+
+	classNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	likeEscaper      = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+)
 
 type DatabaseClass interface {
-	Classes(nameFilter *string, status *string, version *uint32) ([]Class, error)
-	Class(name string) (*Class, error)
-	CreateClass(name, title string) error
-	Elements(c Class, version *uint32, status *string, offset, limit int) ([]Element, int, error)
+	Classes(ctx context.Context, nameFilter *string, status *string, version *uint32) ([]Class, error)
+	Class(ctx context.Context, name string) (*Class, error)
+	// CreateClass создает класс, значения которого могут ссылаться на ключи класса parent
+	CreateClass(ctx context.Context, name, title string, parent *string) error
+	// Elements возвращает до limit значений с next больше after и признак наличия следующих.
+	// Если задан root, выбирается только поддерево значения с ключом root.
+	Elements(ctx context.Context, c Class, version *uint32, status *string, root *string, after int64, limit int) ([]Element, bool, error)
+	// Generation число зафиксированных изменений классов и их значений из class_generation
+	Generation(ctx context.Context) (int64, error)
+	services.OutboxStore
+	Ping(ctx context.Context) error
+	Close() error
 }
 
 type Class struct {
@@ -73,55 +110,106 @@ type Class struct {
 	Current   uint32    `sql:"current"`
 	Status    string    `sql:"status"`
 	UpdatedAt time.Time `sql:"updated_at"`
+	Parent    *string   `sql:"parent"`
 }
 type Element struct {
-	Key     string `sql:"key"`
-	Value   string `sql:"value"`
-	Version uint32 `sql:"version"`
-	Status  string `sql:"status"`
+	Next      int64   `sql:"next"`
+	Key       string  `sql:"key"`
+	Value     string  `sql:"value"`
+	Version   uint32  `sql:"version"`
+	Status    string  `sql:"status"`
+	ParentKey *string `sql:"parent_key"`
 }
 
 type ds struct {
 	db *sql.DB
 }
 
-func (d *ds) CreateClass(name, title string) error {
-	tableName := "class_" + name
-	tx, err := d.db.Begin()
+func (d *ds) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func (d *ds) Close() error {
+	slog.Info("Database pool closed", slog.Any("pool", services.DBStatsValue(d.db)))
+	return d.db.Close()
+}
+
+func (d *ds) CreateClass(ctx context.Context, name, title string, parent *string) error {
+	tableName, err := classTableName(name)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(
-		"INSERT INTO class.classes(name, table_name, title) VALUES ($1, $2, $3)", name, tableName, title)
+	table := pq.QuoteIdentifier(tableName)
+	argument := pq.QuoteLiteral(tableName)
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(fmt.Sprintf(sqlCreateClassTable, tableName))
+	defer func() { _ = tx.Rollback() }()
+	var parentId *uuid.UUID
+	if parent != nil {
+		var id uuid.UUID
+		err = tx.QueryRowContext(ctx, "SELECT id FROM classes WHERE name = $1", *parent).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: parent %q", ErrClassNotFound, *parent)
+		} else if err != nil {
+			return err
+		}
+		parentId = &id
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO classes(name, table_name, title, parent_id) VALUES ($1, $2, $3, $4)",
+		name, tableName, title, parentId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqlCreateClassTable, table))
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(fmt.Sprintf(sqlCreateAfterInsertTrigger, tableName, tableName, tableName))
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqlCreateAfterInsertTrigger,
+		pq.QuoteIdentifier(tableName+"_after_insert"), table, argument))
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(fmt.Sprintf(sqlCreateChangeStatusTrigger, tableName, tableName, tableName))
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqlCreateChangeStatusTrigger,
+		pq.QuoteIdentifier(tableName+"_after_update_status"), table, argument))
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(fmt.Sprintf(sqlCreateAfterUpdateTrigger, tableName, tableName, tableName))
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqlCreateAfterUpdateTrigger,
+		pq.QuoteIdentifier(tableName+"_after_update_after"), table, argument))
 	if err != nil {
 		return err
 	}
-	err = tx.Commit()
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqlCreateCheckParentTrigger,
+		pq.QuoteIdentifier(tableName+"_check_parent"), table, argument))
 	if err != nil {
 		return err
 	}
-	return nil
+	// Поколение для сброса кеша сдвигает триггер classes_after_change, оно видно после фиксации
+	return tx.Commit()
 }
 
-func (d *ds) Elements(c Class, version *uint32, status *string, offset, limit int) ([]Element, int, error) {
-	query := fmt.Sprintf("SELECT next, key, value, version, status FROM class.\"%s\" WHERE 1 = 1", c.TableName)
-	args := make([]interface{}, 0)
+func (d *ds) Elements(ctx context.Context, c Class, version *uint32, status *string, root *string, after int64, limit int) ([]Element, bool, error) {
+	table := pq.QuoteIdentifier(c.TableName)
+	source := table
+	args := []interface{}{after}
+	if root != nil && c.Parent != nil {
+		// parent_key класса с родителем ссылается на ключи родительского класса,
+		// поэтому поддерево состоит из значений, ссылающихся на root
+		source = `(SELECT next, key, value, version, status, parent_key FROM ` + table + ` WHERE parent_key = $2) AS subtree`
+		args = append(args, *root)
+	} else if root != nil {
+		source = `(WITH RECURSIVE tree AS (
+    SELECT next, key, value, version, status, parent_key FROM ` + table + ` WHERE key = $2
+    UNION
+    SELECT e.next, e.key, e.value, e.version, e.status, e.parent_key FROM ` + table + ` e
+        JOIN tree t ON e.parent_key = t.key AND e.version = t.version
+) SELECT * FROM tree) AS subtree`
+		args = append(args, *root)
+	}
+	query := "SELECT next, key, value, version, status, parent_key FROM " + source + " WHERE next > $1"
 	if status != nil {
 		query += " AND status  = $" + strconv.Itoa(len(args)+1)
 		args = append(args, *status)
@@ -130,66 +218,66 @@ func (d *ds) Elements(c Class, version *uint32, status *string, offset, limit in
 		query += " AND version = $" + strconv.Itoa(len(args)+1)
 		args = append(args, *version)
 	}
-	query += " ORDER BY next ASC OFFSET $" + strconv.Itoa(len(args)+1) + " LIMIT $" + strconv.Itoa(len(args)+2)
-	args = append(args, offset, limit)
-	rows, err := d.db.Query(query, args...)
+	query += " ORDER BY next ASC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit+1)
+	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, offset, err
+		return nil, false, err
 	}
 	defer rows.Close()
 	var elements []Element
-	var last = offset
 	for rows.Next() {
 		var element Element
-		err = rows.Scan(&last, &element.Key, &element.Value, &element.Version, &element.Status)
+		err = rows.Scan(&element.Next, &element.Key, &element.Value, &element.Version, &element.Status,
+			&element.ParentKey)
 		if err != nil {
-			return nil, offset, err
+			return nil, false, err
 		}
 		elements = append(elements, element)
 	}
-	return elements, last, nil
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(elements) > limit {
+		return elements[:limit], true, nil
+	}
+	return elements, false, nil
 }
 
-func (d *ds) Class(name string) (*Class, error) {
-	rows, err := d.db.Query("SELECT id, name, title, table_name, current, status, updated_at  FROM class.classes WHERE name = $1", name)
+func (d *ds) Class(ctx context.Context, name string) (*Class, error) {
+	rows, err := d.db.QueryContext(ctx, sqlSelectClass+" AND c.name = $1", name)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var class Class
 	if rows.Next() {
-		err = rows.Scan(&class.Id, &class.Name, &class.Title, &class.TableName, &class.Current,
-			&class.Status, &class.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		return &class, nil
+		return scanClass(rows)
 	}
-	return nil, nil
+	return nil, rows.Err()
 }
 
-func (d *ds) Classes(nameFilter *string, status *string, version *uint32) ([]Class, error) {
-	query := "SELECT id, name, title, table_name, current, status, updated_at FROM class.classes WHERE 1 = 1"
+func (d *ds) Classes(ctx context.Context, nameFilter *string, status *string, version *uint32) ([]Class, error) {
+	query := sqlSelectClass
 	args := make([]interface{}, 0)
 	if nameFilter != nil {
-		query += " AND name LIKE '%$" + strconv.Itoa(len(args)+1) + "%'"
-		args = append(args, *nameFilter)
+		query += " AND c.name LIKE '%' || $" + strconv.Itoa(len(args)+1) + " || '%' ESCAPE '\\'"
+		args = append(args, likeEscaper.Replace(*nameFilter))
 	}
 	if status != nil {
-		query += " AND status  = $" + strconv.Itoa(len(args)+1)
+		query += " AND c.status  = $" + strconv.Itoa(len(args)+1)
 		args = append(args, *status)
 	}
 	if version != nil {
-		query += " AND version = $" + strconv.Itoa(len(args)+1)
+		query += " AND c.current = $" + strconv.Itoa(len(args)+1)
 		args = append(args, *version)
 	}
 
 	var rows *sql.Rows
 	var err error
 	if len(args) == 0 {
-		rows, err = d.db.Query(query)
+		rows, err = d.db.QueryContext(ctx, query)
 	} else {
-		rows, err = d.db.Query(query, args...)
+		rows, err = d.db.QueryContext(ctx, query, args...)
 	}
 	if err != nil {
 		return nil, err
@@ -197,21 +285,53 @@ func (d *ds) Classes(nameFilter *string, status *string, version *uint32) ([]Cla
 	defer rows.Close()
 	result := make([]Class, 0)
 	for rows.Next() {
-		var class Class
-		err = rows.Scan(&class.Id, &class.Name, &class.Title, &class.TableName, &class.Current,
-			&class.Status, &class.UpdatedAt)
+		class, err := scanClass(rows)
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, class)
+		result = append(result, *class)
+	}
+	return result, rows.Err()
+}
+
+func scanClass(rows *sql.Rows) (*Class, error) {
+	var class Class
+	err := rows.Scan(&class.Id, &class.Name, &class.Title, &class.TableName, &class.Current,
+		&class.Status, &class.UpdatedAt, &class.Parent)
+	if err != nil {
+		return nil, err
+	}
+	return &class, nil
+}
+
+func (d *ds) Generation(ctx context.Context) (int64, error) {
+	var generation int64
+	err := d.db.QueryRowContext(ctx, "SELECT value FROM class_generation").Scan(&generation)
+	return generation, err
+}
+
+// RelayOutbox публикует события ElementPublished, которые триггеры значений
+// записывают в outbox
+func (d *ds) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, services.OutboxEvent) error) (int, error) {
+	return services.RelayOutbox(ctx, d.db, limit, publish)
+}
+
+// classTableName проверяет имя класса и возвращает имя таблицы его значений
+func classTableName(name string) (string, error) {
+	if len(name) == 0 || len(name) > maxClassNameLength || !classNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidClassName, name)
 	}
-	return result, nil
+	return classTablePrefix + name, nil
 }
 
-func NewDatabaseClass() DatabaseClass {
-	db, err := services.NewDatabase(migrations)
+func NewDatabaseClass(ctx context.Context, cfg *services.Config) (DatabaseClass, error) {
+	if cfg.Database.InMemory() {
+		slog.Warn("Data is kept in memory and will be lost on restart")
+		return NewMemoryDatabaseClass(), nil
+	}
+	db, err := services.NewDatabase(ctx, cfg, migrations.Class)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &ds{db}
+	return &ds{db}, nil
 }
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"pet/services/testkit"
+)
+
+var hostileClassNames = []string{
+	"",
+	"Sex",
+	"1sex",
+	"_sex",
+	"sex value",
+	"sex;",
+	"sex'",
+	`sex"`,
+	"sex--",
+	"sex$1",
+	"пол",
+	"sex\x00",
+	"x; DROP TABLE classes; --",
+	`x"; DROP TABLE classes; --`,
+	"x'); DROP TABLE classes; --",
+	strings.Repeat("a", maxClassNameLength+1),
+}
+
+func TestClassTableName_Valid(t *testing.T) {
+	for _, name := range []string{"sex", "main", "a", "region_2", strings.Repeat("a", maxClassNameLength)} {
+		tableName, err := classTableName(name)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", name, err)
+		}
+		if tableName != classTablePrefix+name {
+			t.Fatalf("%q: unexpected table name %q", name, tableName)
+		}
+	}
+}
+
+func TestClassTableName_Hostile(t *testing.T) {
+	for _, name := range hostileClassNames {
+		if _, err := classTableName(name); !errors.Is(err, ErrInvalidClassName) {
+			t.Fatalf("%q: expected ErrInvalidClassName, got %v", name, err)
+		}
+	}
+}
+
+func TestClassTableName_IdentifierLength(t *testing.T) {
+	tableName, err := classTableName(strings.Repeat("a", maxClassNameLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(tableName + "_after_update_status"); l > maxIdentifierLength {
+		t.Fatalf("trigger name is %d bytes long", l)
+	}
+}
+
+func TestDatabaseClass_CreateClassHostile(t *testing.T) {
+	d := testDatabase(t)
+	ctx := context.Background()
+	before, err := d.Classes(ctx, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range hostileClassNames {
+		if err = d.CreateClass(ctx, name, "hostile", nil); !errors.Is(err, ErrInvalidClassName) {
+			t.Fatalf("%q: expected ErrInvalidClassName, got %v", name, err)
+		}
+	}
+	after, err := d.Classes(ctx, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("classes changed: %d -> %d", len(before), len(after))
+	}
+}
+
+func TestDatabaseClass_ClassesFilter(t *testing.T) {
+	d := testDatabase(t)
+	ctx := context.Background()
+	name := fmt.Sprintf("f%d_ab", time.Now().UnixNano())
+	if err := d.CreateClass(ctx, name, "Filter probe", nil); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		name:                        true,
+		"_ab":                       true,
+		"_":                         true,
+		"%":                         false,
+		"x_ab":                      false,
+		`\`:                         false,
+		"'":                         false,
+		"' OR '1' = '1":             false,
+		"'; DROP TABLE classes; --": false,
+		"%' OR name LIKE '%":        false,
+	}
+	for filter, expected := range cases {
+		classes, err := d.Classes(ctx, &filter, nil, nil)
+		if err != nil {
+			t.Fatalf("%q: %v", filter, err)
+		}
+		found := false
+		for _, c := range classes {
+			if !strings.Contains(c.Name, filter) {
+				t.Fatalf("%q: unexpected class %q", filter, c.Name)
+			}
+			found = found || c.Name == name
+		}
+		if found != expected {
+			t.Fatalf("%q: expected probe found %v, got %v", filter, expected, found)
+		}
+	}
+}
+
+func TestDatabaseClass_ElementsQuotedTable(t *testing.T) {
+	d := testDatabase(t)
+	ctx := context.Background()
+	c, err := d.Class(ctx, "sex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil {
+		t.Fatal("class sex not found")
+	}
+	c.TableName = `class_sex" WHERE 1 = 0; --`
+	if _, _, err = d.Elements(ctx, *c, nil, nil, nil, 0, 10); err == nil {
+		t.Fatal("expected error for a hostile table name")
+	}
+}
+
+func TestDatabaseClass_ElementsKeysetPagination(t *testing.T) {
+	d := testDatabase(t)
+	ctx := context.Background()
+	name := fmt.Sprintf("p%d", time.Now().UnixNano())
+	if err := d.CreateClass(ctx, name, "Pagination probe", nil); err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Class(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := "INSERT INTO " + pq.QuoteIdentifier(c.TableName) + "(key, value, status) VALUES ($1, $2, $3)"
+	expected := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		status := "ITEM_DRAFT"
+		if i%3 != 0 {
+			status = "ITEM_PUBLISHED"
+			expected[fmt.Sprintf("k%03d", i)] = true
+		}
+		if _, err = d.db.Exec(insert, fmt.Sprintf("k%03d", i), "value", status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			status := "ITEM_PUBLISHED"
+			if i%2 == 0 {
+				status = "ITEM_DRAFT"
+			}
+			if _, err := d.db.Exec(insert, fmt.Sprintf("c%05d", i), "concurrent", status); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	published := "ITEM_PUBLISHED"
+	seen := make(map[string]bool)
+	var after int64
+	for {
+		elements, more, err := d.Elements(ctx, *c, nil, &published, nil, after, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, element := range elements {
+			if element.Next <= after {
+				t.Fatalf("cursor went backwards: %d after %d", element.Next, after)
+			}
+			if element.Status != published {
+				t.Fatalf("unexpected status %s", element.Status)
+			}
+			if seen[element.Key] {
+				t.Fatalf("element %s repeated", element.Key)
+			}
+			seen[element.Key] = true
+			after = element.Next
+		}
+		if !more {
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+	for key := range expected {
+		if !seen[key] {
+			t.Fatalf("element %s skipped", key)
+		}
+	}
+}
+
+func TestDatabaseClass_Hierarchy(t *testing.T) {
+	d := testDatabase(t)
+	ctx := context.Background()
+	suffix := time.Now().UnixNano()
+	country, region := fmt.Sprintf("country%d", suffix), fmt.Sprintf("region%d", suffix)
+	missing := fmt.Sprintf("missing%d", suffix)
+	if err := d.CreateClass(ctx, region, "Region", &missing); !errors.Is(err, ErrClassNotFound) {
+		t.Fatalf("expected ErrClassNotFound, got %v", err)
+	}
+	if err := d.CreateClass(ctx, country, "Country", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateClass(ctx, region, "Region", &country); err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Class(ctx, region)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Parent == nil || *c.Parent != country {
+		t.Fatalf("expected parent %s, got %v", country, c.Parent)
+	}
+	countries := "INSERT INTO " + pq.QuoteIdentifier(classTablePrefix+country) + "(key, value, version) VALUES ($1, $2, $3)"
+	regions := "INSERT INTO " + pq.QuoteIdentifier(classTablePrefix+region) +
+		"(key, value, version, parent_key) VALUES ($1, $2, $3, $4)"
+	if _, err = d.db.Exec(countries, "ru", "Россия", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.db.Exec(regions, "msk", "Москва", 1, "ru"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.db.Exec(regions, "spb", "Санкт-Петербург", 2, "ru"); err == nil {
+		t.Fatal("expected parent check error for another version")
+	}
+	if _, err = d.db.Exec(regions, "nyc", "Нью-Йорк", 1, "us"); err == nil {
+		t.Fatal("expected parent check error for unknown key")
+	}
+	// Ключ региона совпадает с ключом другой страны
+	if _, err = d.db.Exec(countries, "us", "США", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.db.Exec(regions, "us", "Уссурийск", 1, "ru"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.db.Exec(regions, "nyc", "Нью-Йорк", 1, "us"); err != nil {
+		t.Fatal(err)
+	}
+	root := "ru"
+	elements, _, err := d.Elements(ctx, *c, nil, nil, &root, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 2 || elements[0].Key != "msk" || elements[1].Key != "us" {
+		t.Fatalf("regions of ru expected, got %v", elements)
+	}
+}
+
+func TestDatabaseClass_Subtree(t *testing.T) {
+	d := testDatabase(t)
+	ctx := context.Background()
+	name := fmt.Sprintf("category%d", time.Now().UnixNano())
+	if err := d.CreateClass(ctx, name, "Category", nil); err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Class(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := "INSERT INTO " + pq.QuoteIdentifier(c.TableName) + "(key, value, parent_key) VALUES ($1, $2, $3)"
+	for _, row := range [][2]string{{"a", ""}, {"b", "a"}, {"c", "b"}, {"d", ""}, {"e", "d"}, {"f", "a"}} {
+		var parent *string
+		if row[1] != "" {
+			parent = &row[1]
+		}
+		if _, err = d.db.Exec(insert, row[0], "value "+row[0], parent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = d.db.Exec(insert, "g", "value g", "z"); err == nil {
+		t.Fatal("expected parent check error")
+	}
+	root := "a"
+	var keys []string
+	var after int64
+	for {
+		elements, more, err := d.Elements(ctx, *c, nil, nil, &root, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, element := range elements {
+			keys = append(keys, element.Key)
+			after = element.Next
+		}
+		if !more {
+			break
+		}
+	}
+	if strings.Join(keys, ",") != "a,b,c,f" {
+		t.Fatalf("unexpected subtree %v", keys)
+	}
+}
+
+func TestDatabaseClass_GenerationOnClassChange(t *testing.T) {
+	d := testDatabase(t)
+	ctx := context.Background()
+	before, err := d.Generation(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("generation%d", time.Now().UnixNano())
+	if err = d.CreateClass(ctx, name, "Generation", nil); err != nil {
+		t.Fatal(err)
+	}
+	created, _ := d.Generation(ctx)
+	if created <= before {
+		t.Fatalf("new class must shift the generation, %d -> %d", before, created)
+	}
+	if _, err = d.db.ExecContext(ctx, "UPDATE classes SET status = 'CLASS_PUBLISHED' WHERE name = $1", name); err != nil {
+		t.Fatal(err)
+	}
+	if published, _ := d.Generation(ctx); published <= created {
+		t.Fatalf("class status change must shift the generation, %d -> %d", created, published)
+	}
+}
+
+// testDatabase подключается к отдельной схеме локального Postgres
+func testDatabase(t *testing.T) *ds {
+	t.Helper()
+	return &ds{testkit.Database(t, "class")}
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(testkit.Run(m))
+}
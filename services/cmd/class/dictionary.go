@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+// dictionary все значения одной версии класса, индексированные по ключу
+type dictionary struct {
+	class    Class
+	version  uint32
+	elements map[string]Element
+	ready    chan struct{}
+	err      error
+}
+
+type dictionaryKey struct {
+	name    string
+	version uint32
+}
+
+const (
+	// maxDictionaries наибольшее число словарей в памяти, сверх него словари
+	// загружаются без сохранения
+	maxDictionaries = 1000
+	// dictionaryLoadTimeout наибольшее время загрузки словаря
+	dictionaryLoadTimeout = 30 * time.Second
+)
+
+// dictionaries кеш словарей в памяти процесса для Lookup и Translate.
+// Сбрасывается целиком при смене поколения изменений классов.
+type dictionaries struct {
+	db         DatabaseClass
+	changes    *changeLog
+	mu         sync.Mutex
+	generation int64
+	entries    map[dictionaryKey]*dictionary
+}
+
+func newDictionaries(db DatabaseClass, changes *changeLog) *dictionaries {
+	return &dictionaries{
+		db:         db,
+		changes:    changes,
+		generation: -1,
+		entries:    make(map[dictionaryKey]*dictionary),
+	}
+}
+
+// get возвращает словарь класса name версии version, по умолчанию текущей
+// версии класса. Словарь загружается один раз для всех ожидающих его вызовов,
+// каждый из которых ждет не дольше своего ctx.
+func (d *dictionaries) get(ctx context.Context, name string, version *uint32) (*dictionary, error) {
+	var v uint32
+	if version != nil {
+		v = *version
+	}
+	key := dictionaryKey{name: name, version: v}
+	generation := d.changes.current()
+	d.mu.Lock()
+	if generation != d.generation {
+		d.entries = make(map[dictionaryKey]*dictionary)
+		d.generation = generation
+	}
+	entry, ok := d.entries[key]
+	if !ok {
+		entry = &dictionary{ready: make(chan struct{})}
+		if generation >= 0 && len(d.entries) < maxDictionaries {
+			d.entries[key] = entry
+		}
+		// Загрузку разделяют несколько вызовов, поэтому отмена одного из них ее не прерывает
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dictionaryLoadTimeout)
+		go func() {
+			defer cancel()
+			d.fill(loadCtx, key, entry, name, version)
+		}()
+	}
+	d.mu.Unlock()
+	select {
+	case <-entry.ready:
+		return entry, entry.err
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// fill загружает словарь entry. Словари с ошибкой и пустые словари явно
+// запрошенных версий не сохраняются, чтобы клиенты не заполняли память
+// несуществующими версиями.
+func (d *dictionaries) fill(ctx context.Context, key dictionaryKey, entry *dictionary, name string, version *uint32) {
+	entry.err = d.load(ctx, entry, name, version)
+	if entry.err != nil || version != nil && len(entry.elements) == 0 {
+		d.mu.Lock()
+		if d.entries[key] == entry {
+			delete(d.entries, key)
+		}
+		d.mu.Unlock()
+	}
+	close(entry.ready)
+}
+
+func (d *dictionaries) load(ctx context.Context, entry *dictionary, name string, version *uint32) error {
+	c, err := d.db.Class(ctx, name)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return ErrClassNotFound
+	}
+	entry.class = *c
+	entry.version = c.Current
+	if version != nil {
+		entry.version = *version
+	}
+	entry.elements = make(map[string]Element)
+	var after int64
+	for {
+		elements, more, err := d.db.Elements(ctx, *c, &entry.version, nil, nil, after, maxPageLimit)
+		if err != nil {
+			return err
+		}
+		for _, element := range elements {
+			if previous, ok := entry.elements[element.Key]; ok && !preferElement(element, previous) {
+				continue
+			}
+			entry.elements[element.Key] = element
+		}
+		if !more || len(elements) == 0 {
+			return nil
+		}
+		after = elements[len(elements)-1].Next
+	}
+}
+
+// preferElement выбирает значение при нескольких значениях одного ключа в версии:
+// опубликованное важнее остальных, среди равных побеждает более позднее
+func preferElement(candidate, current Element) bool {
+	const published = "ITEM_PUBLISHED"
+	if (candidate.Status == published) != (current.Status == published) {
+		return candidate.Status == published
+	}
+	return candidate.Next > current.Next
+}
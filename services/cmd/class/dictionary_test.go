@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pet/middleware/class"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type dictionaryDatabase struct {
+	DatabaseClass
+	generation int64
+	loads      int
+	classes    map[string][]Element
+}
+
+func (d *dictionaryDatabase) Class(_ context.Context, name string) (*Class, error) {
+	if _, ok := d.classes[name]; !ok {
+		return nil, nil
+	}
+	return &Class{Name: name, TableName: classTablePrefix + name, Current: 1}, nil
+}
+
+func (d *dictionaryDatabase) Elements(_ context.Context, c Class, version *uint32, _ *string, _ *string, after int64, limit int) ([]Element, bool, error) {
+	d.loads++
+	var result []Element
+	for _, element := range d.classes[c.Name] {
+		if element.Next > after && (version == nil || element.Version == *version) {
+			result = append(result, element)
+		}
+	}
+	if len(result) > limit {
+		return result[:limit], true, nil
+	}
+	return result, false, nil
+}
+
+func (d *dictionaryDatabase) Generation(context.Context) (int64, error) {
+	return d.generation, nil
+}
+
+func newDictionaryService(db *dictionaryDatabase) (*service, *changeLog) {
+	changes := newChangeLog(db)
+	changes.refresh(context.Background())
+	return &service{db: db, dictionaries: newDictionaries(db, changes)}, changes
+}
+
+func dictionaryFixture() *dictionaryDatabase {
+	return &dictionaryDatabase{
+		generation: 3,
+		classes: map[string][]Element{
+			"sex": {
+				{Next: 1, Key: "m", Value: "мужской", Version: 1, Status: "ITEM_PUBLISHED"},
+				{Next: 2, Key: "f", Value: "женский", Version: 1, Status: "ITEM_PUBLISHED"},
+				{Next: 3, Key: "f", Value: "женский (черновик)", Version: 1, Status: "ITEM_DRAFT"},
+				{Next: 4, Key: "n", Value: "не определен", Version: 2, Status: "ITEM_PUBLISHED"},
+			},
+			"yes": {
+				{Next: 1, Key: "y", Value: "да", Version: 1, Status: "ITEM_PUBLISHED"},
+			},
+		},
+	}
+}
+
+func TestService_Lookup(t *testing.T) {
+	s, _ := newDictionaryService(dictionaryFixture())
+	reply, err := s.Lookup(context.Background(), &class.LookupRequest{Name: "sex", Keys: []string{"m", "f", "n"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Version != 1 || len(reply.Elements) != 2 {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	if reply.Elements[1].Value != "женский" || reply.Elements[1].Status != class.ClassElementStatus_ITEM_PUBLISHED {
+		t.Fatalf("published value expected, got %v", reply.Elements[1])
+	}
+	if len(reply.Missing) != 1 || reply.Missing[0] != "n" {
+		t.Fatalf("expected missing n, got %v", reply.Missing)
+	}
+
+	var version uint32 = 2
+	reply, err = s.Lookup(context.Background(), &class.LookupRequest{Name: "sex", Keys: []string{"n"}, Version: &version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Elements) != 1 || reply.Elements[0].Value != "не определен" {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+}
+
+func TestService_LookupUnknownClass(t *testing.T) {
+	s, _ := newDictionaryService(dictionaryFixture())
+	_, err := s.Lookup(context.Background(), &class.LookupRequest{Name: "unknown", Keys: []string{"m"}})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+func TestService_Translate(t *testing.T) {
+	db := dictionaryFixture()
+	s, changes := newDictionaryService(db)
+	request := &class.TranslateRequest{Items: []*class.TranslateItem{
+		{Name: "sex", Key: "m"},
+		{Name: "yes", Key: "y"},
+		{Name: "sex", Key: "f"},
+		{Name: "sex", Key: "x"},
+		{Name: "unknown", Key: "m"},
+	}}
+	reply, err := s.Translate(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		found bool
+		value string
+	}{{true, "мужской"}, {true, "да"}, {true, "женский"}, {false, ""}, {false, ""}}
+	if len(reply.Items) != len(expected) {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	for i, item := range reply.Items {
+		if item.Found != expected[i].found || item.Value != expected[i].value {
+			t.Fatalf("item %d: unexpected translation %v", i, item)
+		}
+	}
+	if db.loads != 2 {
+		t.Fatalf("expected one load per class, got %d", db.loads)
+	}
+	if _, err = s.Translate(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if db.loads != 2 {
+		t.Fatalf("expected dictionaries served from memory, got %d loads", db.loads)
+	}
+	db.generation++
+	changes.refresh(context.Background())
+	if _, err = s.Translate(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if db.loads != 4 {
+		t.Fatalf("expected reload after generation change, got %d loads", db.loads)
+	}
+}
+
+func TestService_TranslateTooManyItems(t *testing.T) {
+	s, _ := newDictionaryService(dictionaryFixture())
+	items := make([]*class.TranslateItem, maxTranslateItems+1)
+	for i := range items {
+		items[i] = &class.TranslateItem{Name: "sex", Key: "m"}
+	}
+	_, err := s.Translate(context.Background(), &class.TranslateRequest{Items: items})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestDictionaries_MissingVersionNotCached(t *testing.T) {
+	db := dictionaryFixture()
+	s, _ := newDictionaryService(db)
+	for version := uint32(10); version < 20; version++ {
+		d, err := s.dictionaries.get(context.Background(), "sex", &version)
+		if err != nil || len(d.elements) != 0 {
+			t.Fatalf("empty dictionary expected, got %v, %v", d, err)
+		}
+	}
+	if len(s.dictionaries.entries) != 0 {
+		t.Fatalf("missing versions must not be kept, got %d dictionaries", len(s.dictionaries.entries))
+	}
+}
+
+// blockingDatabase загружает значения только после закрытия release
+type blockingDatabase struct {
+	*dictionaryDatabase
+	release chan struct{}
+}
+
+func (d *blockingDatabase) Elements(ctx context.Context, c Class, version *uint32, status *string, root *string, after int64, limit int) ([]Element, bool, error) {
+	select {
+	case <-d.release:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	return d.dictionaryDatabase.Elements(ctx, c, version, status, root, after, limit)
+}
+
+func TestDictionaries_WaiterCancel(t *testing.T) {
+	db := &blockingDatabase{dictionaryDatabase: dictionaryFixture(), release: make(chan struct{})}
+	changes := newChangeLog(db)
+	changes.refresh(context.Background())
+	d := newDictionaries(db, changes)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := d.get(ctx, "sex", nil); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("DeadlineExceeded expected while loading, got %v", err)
+	}
+	// Загрузка продолжается для следующих вызовов
+	close(db.release)
+	dict, err := d.get(context.Background(), "sex", nil)
+	if err != nil || len(dict.elements) != 2 {
+		t.Fatalf("loaded dictionary expected, got %v, %v", dict, err)
+	}
+	if db.loads != 1 {
+		t.Fatalf("one load expected, got %d", db.loads)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pet/services"
+)
+
+var (
+	statusesClass   = []string{"CLASS_DRAFT", "CLASS_PUBLISHED", "CLASS_ARCHIVED"}
+	statusesElement = []string{"ITEM_DRAFT", "ITEM_PUBLISHED", "ITEM_SKIP"}
+)
+
+// memoryClass класс и таблица его значений
+type memoryClass struct {
+	Class
+	parentId *uuid.UUID
+	elements []Element
+	next     int64
+}
+
+// memoryDatabase хранит классы в памяти процесса по правилам схемы Postgres:
+// уникальные имена классов и значения (key, value, version), проверка
+// parent_key и журнал изменений значений, который в базе ведут триггеры
+type memoryDatabase struct {
+	mu      sync.RWMutex
+	classes []*memoryClass
+	// changes число изменений, как class_generation
+	changes int64
+	now     func() time.Time
+	// MemoryOutbox события ElementPublished, которые в базе пишут триггеры
+	services.MemoryOutbox
+}
+
+// NewMemoryDatabaseClass создает хранилище классов в памяти с классом sex,
+// как после миграций схемы
+func NewMemoryDatabaseClass() DatabaseClass {
+	d := newMemoryDatabase()
+	_ = d.createClass("sex", "Пол человека", nil)
+	for _, value := range [][2]string{{"m", "мужской"}, {"f", "женский"}, {"n", "не определен"}} {
+		_, _ = d.Insert("sex", Element{Key: value[0], Value: value[1]})
+	}
+	return d
+}
+
+func newMemoryDatabase() *memoryDatabase {
+	return &memoryDatabase{now: time.Now}
+}
+
+func (d *memoryDatabase) class(name string) *memoryClass {
+	for _, c := range d.classes {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *memoryDatabase) classById(id uuid.UUID) *memoryClass {
+	for _, c := range d.classes {
+		if c.Id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// view копия класса с именем родителя, как ее возвращает sqlSelectClass
+func (d *memoryDatabase) view(c *memoryClass) Class {
+	class := c.Class
+	if c.parentId != nil {
+		if parent := d.classById(*c.parentId); parent != nil {
+			class.Parent = &parent.Name
+		}
+	}
+	return class
+}
+
+func (d *memoryDatabase) Classes(_ context.Context, nameFilter *string, status *string, version *uint32) ([]Class, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	result := make([]Class, 0)
+	for _, c := range d.classes {
+		if nameFilter != nil && !strings.Contains(c.Name, *nameFilter) {
+			continue
+		}
+		if status != nil && c.Status != *status {
+			continue
+		}
+		if version != nil && c.Current != *version {
+			continue
+		}
+		result = append(result, d.view(c))
+	}
+	return result, nil
+}
+
+func (d *memoryDatabase) Class(_ context.Context, name string) (*Class, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	c := d.class(name)
+	if c == nil {
+		return nil, nil
+	}
+	class := d.view(c)
+	return &class, nil
+}
+
+func (d *memoryDatabase) CreateClass(_ context.Context, name, title string, parent *string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.createClass(name, title, parent); err != nil {
+		return err
+	}
+	// Как и в базе, новый класс сдвигает поколение для сброса кеша
+	d.changes++
+	return nil
+}
+
+func (d *memoryDatabase) createClass(name, title string, parent *string) error {
+	tableName, err := classTableName(name)
+	if err != nil {
+		return err
+	}
+	var parentId *uuid.UUID
+	if parent != nil {
+		p := d.class(*parent)
+		if p == nil {
+			return fmt.Errorf("%w: parent %q", ErrClassNotFound, *parent)
+		}
+		parentId = &p.Id
+	}
+	if d.class(name) != nil {
+		return fmt.Errorf("class %q already exists", name)
+	}
+	d.classes = append(d.classes, &memoryClass{
+		Class: Class{
+			Id:        uuid.New(),
+			Name:      name,
+			Title:     title,
+			TableName: tableName,
+			Current:   1,
+			Status:    statusesClass[0],
+			UpdatedAt: d.now(),
+		},
+		parentId: parentId,
+	})
+	return nil
+}
+
+// Insert добавляет значение в класс name. Поля Next, Version и Status
+// заполняются как значения по умолчанию таблицы класса.
+func (d *memoryDatabase) Insert(name string, element Element) (Element, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.class(name)
+	if c == nil {
+		return Element{}, fmt.Errorf("%w: %q", ErrClassNotFound, name)
+	}
+	if element.Version == 0 {
+		element.Version = 1
+	}
+	if element.Status == "" {
+		element.Status = statusesElement[0]
+	}
+	if !slices.Contains(statusesElement, element.Status) {
+		return Element{}, fmt.Errorf("invalid element status %q", element.Status)
+	}
+	for _, e := range c.elements {
+		if e.Key == element.Key && e.Value == element.Value && e.Version == element.Version {
+			return Element{}, fmt.Errorf("element %q = %q version %d already exists in class %q",
+				element.Key, element.Value, element.Version, name)
+		}
+	}
+	if err := d.checkParent(c, element); err != nil {
+		return Element{}, err
+	}
+	c.next++
+	element.Next = c.next
+	c.elements = append(c.elements, element)
+	d.changes++
+	if element.Status == "ITEM_PUBLISHED" {
+		d.published(name, element)
+	}
+	return element, nil
+}
+
+// SetStatus меняет статус значений ключа key версии version класса name
+func (d *memoryDatabase) SetStatus(name, key string, version uint32, status string) error {
+	if !slices.Contains(statusesElement, status) {
+		return fmt.Errorf("invalid element status %q", status)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.class(name)
+	if c == nil {
+		return fmt.Errorf("%w: %q", ErrClassNotFound, name)
+	}
+	for i, e := range c.elements {
+		if e.Key == key && e.Version == version && e.Status != status {
+			c.elements[i].Status = status
+			d.changes++
+			if status == "ITEM_PUBLISHED" {
+				d.published(name, e)
+			}
+		}
+	}
+	return nil
+}
+
+// published повторяет триггерную функцию fn_element_published
+func (d *memoryDatabase) published(name string, e Element) {
+	d.Write(services.EventElementPublished, name+":"+e.Key, services.ElementPublished{
+		Class: name, Key: e.Key, Value: e.Value, Version: e.Version,
+	})
+}
+
+// checkParent повторяет триггер fn_check_parent_key: родительский ключ ищется
+// в родительском классе, а без него в самом классе
+func (d *memoryDatabase) checkParent(c *memoryClass, element Element) error {
+	if element.ParentKey == nil {
+		return nil
+	}
+	parent := c
+	if c.parentId != nil {
+		parent = d.classById(*c.parentId)
+	}
+	for _, e := range parent.elements {
+		if e.Key == *element.ParentKey && e.Version == element.Version {
+			return nil
+		}
+	}
+	return fmt.Errorf("parent key %s not found in %s version %d", *element.ParentKey, parent.TableName,
+		element.Version)
+}
+
+func (d *memoryDatabase) Elements(_ context.Context, c Class, version *uint32, status *string, root *string, after int64, limit int) ([]Element, bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	// Значения выбираются по таблице класса, как в базе
+	var stored *memoryClass
+	for _, candidate := range d.classes {
+		if candidate.TableName == c.TableName {
+			stored = candidate
+		}
+	}
+	if stored == nil {
+		return nil, false, fmt.Errorf("table %q of class %q not found", c.TableName, c.Name)
+	}
+	source := stored.elements
+	if root != nil && stored.parentId != nil {
+		source = children(stored.elements, *root)
+	} else if root != nil {
+		source = subtree(stored.elements, *root)
+	}
+	var elements []Element
+	for _, e := range source {
+		if e.Next <= after {
+			continue
+		}
+		if status != nil && e.Status != *status {
+			continue
+		}
+		if version != nil && e.Version != *version {
+			continue
+		}
+		elements = append(elements, e)
+		if len(elements) > limit {
+			return elements[:limit], true, nil
+		}
+	}
+	return elements, false, nil
+}
+
+// subtree значения с ключом root и их потомки той же версии с сохранением
+// порядка elements
+func subtree(elements []Element, root string) []Element {
+	included := make(map[int64]bool)
+	for _, e := range elements {
+		if e.Key == root {
+			included[e.Next] = true
+		}
+	}
+	for grown := true; grown; {
+		grown = false
+		for _, parent := range elements {
+			if !included[parent.Next] {
+				continue
+			}
+			for _, e := range elements {
+				if !included[e.Next] && e.ParentKey != nil && *e.ParentKey == parent.Key &&
+					e.Version == parent.Version {
+					included[e.Next] = true
+					grown = true
+				}
+			}
+		}
+	}
+	result := make([]Element, 0, len(included))
+	for _, e := range elements {
+		if included[e.Next] {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// children значения класса с родителем, ссылающиеся на ключ root
+// родительского класса
+func children(elements []Element, root string) []Element {
+	var result []Element
+	for _, e := range elements {
+		if e.ParentKey != nil && *e.ParentKey == root {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func (d *memoryDatabase) Generation(context.Context) (int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.changes, nil
+}
+
+func (d *memoryDatabase) Ping(context.Context) error {
+	return nil
+}
+
+func (d *memoryDatabase) Close() error {
+	return nil
+}
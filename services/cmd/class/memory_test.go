@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"pet/middleware/class"
+	"pet/services"
+)
+
+func TestMemoryDatabase_CreateClass(t *testing.T) {
+	d := NewMemoryDatabaseClass().(*memoryDatabase)
+	ctx := context.Background()
+	if generation, _ := d.Generation(ctx); generation != 3 {
+		t.Fatalf("generation of the seeded values expected, got %d", generation)
+	}
+	for _, name := range hostileClassNames {
+		if err := d.CreateClass(ctx, name, "hostile", nil); !errors.Is(err, ErrInvalidClassName) {
+			t.Fatalf("%q: expected ErrInvalidClassName, got %v", name, err)
+		}
+	}
+	missing := "missing"
+	if err := d.CreateClass(ctx, "region", "Region", &missing); !errors.Is(err, ErrClassNotFound) {
+		t.Fatalf("expected ErrClassNotFound, got %v", err)
+	}
+	if err := d.CreateClass(ctx, "sex", "Duplicate", nil); err == nil {
+		t.Fatal("duplicate class created")
+	}
+	country := "country"
+	if err := d.CreateClass(ctx, country, "Country", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateClass(ctx, "region", "Region", &country); err != nil {
+		t.Fatal(err)
+	}
+	if generation, _ := d.Generation(ctx); generation != 5 {
+		t.Fatalf("each class must shift the generation, got %d", generation)
+	}
+	c, err := d.Class(ctx, "region")
+	if err != nil || c == nil || c.Parent == nil || *c.Parent != country || c.TableName != "class_region" {
+		t.Fatalf("region class with parent expected, got %+v, %v", c, err)
+	}
+	filter := "_"
+	if classes, _ := d.Classes(ctx, &filter, nil, nil); len(classes) != 0 {
+		t.Fatalf("filter must match literally, got %v", classes)
+	}
+	filter = "o"
+	if classes, _ := d.Classes(ctx, &filter, nil, nil); len(classes) != 2 {
+		t.Fatalf("country and region expected, got %v", classes)
+	}
+}
+
+func TestMemoryDatabase_Elements(t *testing.T) {
+	d := newMemoryDatabase()
+	ctx := context.Background()
+	_ = d.CreateClass(ctx, "country", "Country", nil)
+	country := "country"
+	_ = d.CreateClass(ctx, "region", "Region", &country)
+	_ = d.CreateClass(ctx, "category", "Category", nil)
+	ru, us := "ru", "us"
+	if _, err := d.Insert("country", Element{Key: ru, Value: "Россия"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Insert("country", Element{Key: ru, Value: "Россия"}); err == nil {
+		t.Fatal("duplicate value inserted")
+	}
+	if _, err := d.Insert("region", Element{Key: "msk", Value: "Москва", ParentKey: &ru}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Insert("region", Element{Key: "spb", Value: "Санкт-Петербург", Version: 2, ParentKey: &ru}); err == nil {
+		t.Fatal("expected parent check error for another version")
+	}
+	if _, err := d.Insert("region", Element{Key: "nyc", Value: "Нью-Йорк", ParentKey: &us}); err == nil {
+		t.Fatal("expected parent check error for unknown key")
+	}
+	// Ключ региона совпадает с ключом другой страны
+	_, _ = d.Insert("country", Element{Key: us, Value: "США"})
+	_, _ = d.Insert("region", Element{Key: us, Value: "Уссурийск", ParentKey: &ru})
+	_, _ = d.Insert("region", Element{Key: "nyc", Value: "Нью-Йорк", ParentKey: &us})
+	r, _ := d.Class(ctx, "region")
+	regions, _, _ := d.Elements(ctx, *r, nil, nil, &ru, 0, 10)
+	if len(regions) != 2 || regions[0].Key != "msk" || regions[1].Key != us {
+		t.Fatalf("regions of ru expected, got %v", regions)
+	}
+
+	for _, row := range [][2]string{{"a", ""}, {"b", "a"}, {"c", "b"}, {"d", ""}, {"e", "d"}, {"f", "a"}} {
+		var parent *string
+		if row[1] != "" {
+			parent = &row[1]
+		}
+		if _, err := d.Insert("category", Element{Key: row[0], Value: "value " + row[0], ParentKey: parent}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, _ := d.Class(ctx, "category")
+	root := "a"
+	var keys []string
+	var after int64
+	for {
+		elements, more, err := d.Elements(ctx, *c, nil, nil, &root, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, element := range elements {
+			keys = append(keys, element.Key)
+			after = element.Next
+		}
+		if !more {
+			break
+		}
+	}
+	if strings.Join(keys, ",") != "a,b,c,f" {
+		t.Fatalf("unexpected subtree %v", keys)
+	}
+
+	before, _ := d.Generation(ctx)
+	if err := d.SetStatus("category", "a", 1, "ITEM_PUBLISHED"); err != nil {
+		t.Fatal(err)
+	}
+	published := "ITEM_PUBLISHED"
+	elements, _, _ := d.Elements(ctx, *c, nil, &published, nil, 0, 10)
+	if len(elements) != 1 || elements[0].Key != "a" {
+		t.Fatalf("published element expected, got %v", elements)
+	}
+	if generation, _ := d.Generation(ctx); generation != before+1 {
+		t.Fatalf("status change must be logged, generation %d -> %d", before, generation)
+	}
+	c.TableName = `class_category" WHERE 1 = 0; --`
+	if _, _, err := d.Elements(ctx, *c, nil, nil, nil, 0, 10); err == nil {
+		t.Fatal("expected error for a hostile table name")
+	}
+}
+
+func TestMemoryDatabase_Outbox(t *testing.T) {
+	d := newMemoryDatabase()
+	ctx := context.Background()
+	_ = d.CreateClass(ctx, "color", "Color", nil)
+	if _, err := d.Insert("color", Element{Key: "red", Value: "#f00", Status: "ITEM_PUBLISHED"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Insert("color", Element{Key: "green", Value: "#0f0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetStatus("color", "green", 1, "ITEM_PUBLISHED"); err != nil {
+		t.Fatal(err)
+	}
+	var events []services.OutboxEvent
+	collect := func(_ context.Context, e services.OutboxEvent) error {
+		events = append(events, e)
+		return nil
+	}
+	if _, err := d.RelayOutbox(ctx, 10, collect); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Aggregate != "color:red" || events[1].Aggregate != "color:green" {
+		t.Fatalf("published elements expected, got %+v", events)
+	}
+	var p services.ElementPublished
+	if err := json.Unmarshal(events[1].Payload, &p); err != nil {
+		t.Fatal(err)
+	}
+	if events[1].Type != services.EventElementPublished || p.Class != "color" || p.Value != "#0f0" || p.Version != 1 {
+		t.Fatalf("green element published expected, got %s %+v", events[1].Type, p)
+	}
+}
+
+func TestService_MemoryDatabase(t *testing.T) {
+	db := NewMemoryDatabaseClass()
+	changes := newChangeLog(db)
+	changes.refresh(context.Background())
+	s := &service{
+		db:           db,
+		cache:        newClassCache(nil, changes, time.Minute),
+		dictionaries: newDictionaries(db, changes),
+	}
+	ctx := context.Background()
+	classes, err := s.Classes(ctx, &class.ClassRequest{})
+	if err != nil || len(classes.Classes) != 1 || classes.Classes[0].Name != "sex" {
+		t.Fatalf("class sex expected, got %v, %v", classes, err)
+	}
+	elements, err := s.Elements(ctx, &class.ClassElementRequest{Name: "sex"})
+	if err != nil || len(elements.Elements) != 3 || !elements.Eof {
+		t.Fatalf("three elements of class sex expected, got %v, %v", elements, err)
+	}
+	lookup, err := s.Lookup(ctx, &class.LookupRequest{Name: "sex", Keys: []string{"m", "x"}})
+	if err != nil || len(lookup.Elements) != 1 || len(lookup.Missing) != 1 {
+		t.Fatalf("one found and one missing key expected, got %v, %v", lookup, err)
+	}
+}
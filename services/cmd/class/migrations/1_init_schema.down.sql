@@ -1 +0,0 @@
-DROP TABLE classes;
\ No newline at end of file
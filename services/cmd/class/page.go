@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// pageToken курсор постраничной выборки значений класса. Содержит последний
+// прочитанный next и отпечаток фильтров, с которыми он был получен.
+type pageToken struct {
+	After  int64
+	Filter string
+}
+
+// pageFilter отпечаток параметров запроса, от которых зависит последовательность строк
+func pageFilter(name string, version *uint32, status *string, root *string) string {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	if version != nil {
+		h.Write([]byte(strconv.FormatUint(uint64(*version), 10)))
+	}
+	h.Write([]byte{0})
+	if status != nil {
+		h.Write([]byte(*status))
+	}
+	h.Write([]byte{0})
+	if root != nil {
+		h.Write([]byte(*root))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func (p pageToken) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(p.After, 10) + ":" + p.Filter))
+}
+
+// parsePageToken разбирает курсор и проверяет, что он выдан для тех же фильтров
+func parsePageToken(token, filter string) (pageToken, error) {
+	if token == "" {
+		return pageToken{Filter: filter}, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return pageToken{}, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+	after, fingerprint, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return pageToken{}, ErrInvalidPageToken
+	}
+	next, err := strconv.ParseInt(after, 10, 64)
+	if err != nil || next < 0 {
+		return pageToken{}, ErrInvalidPageToken
+	}
+	if fingerprint != filter {
+		return pageToken{}, fmt.Errorf("%w: filters changed", ErrInvalidPageToken)
+	}
+	return pageToken{After: next, Filter: filter}, nil
+}
+
+// pageLimit приводит запрошенный размер страницы к допустимому диапазону
+func pageLimit(limit *uint32) int {
+	if limit == nil || *limit == 0 {
+		return defaultPageLimit
+	}
+	if *limit > maxPageLimit {
+		return maxPageLimit
+	}
+	return int(*limit)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPageToken_RoundTrip(t *testing.T) {
+	status := "ITEM_PUBLISHED"
+	filter := pageFilter("sex", nil, &status, nil)
+	token := pageToken{After: 42, Filter: filter}.String()
+	page, err := parsePageToken(token, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.After != 42 {
+		t.Fatalf("expected cursor 42, got %d", page.After)
+	}
+}
+
+func TestPageToken_Empty(t *testing.T) {
+	page, err := parsePageToken("", pageFilter("sex", nil, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.After != 0 {
+		t.Fatalf("expected first page, got %d", page.After)
+	}
+}
+
+func TestPageToken_FiltersChanged(t *testing.T) {
+	published, draft := "ITEM_PUBLISHED", "ITEM_DRAFT"
+	var version uint32 = 2
+	token := pageToken{After: 1, Filter: pageFilter("sex", nil, &published, nil)}.String()
+	for _, filter := range []string{
+		pageFilter("sex", nil, &draft, nil),
+		pageFilter("sex", nil, nil, nil),
+		pageFilter("sex", &version, &published, nil),
+		pageFilter("main", nil, &published, nil),
+	} {
+		if _, err := parsePageToken(token, filter); !errors.Is(err, ErrInvalidPageToken) {
+			t.Fatalf("expected ErrInvalidPageToken, got %v", err)
+		}
+	}
+}
+
+func TestPageToken_Malformed(t *testing.T) {
+	filter := pageFilter("sex", nil, nil, nil)
+	for _, token := range []string{"%%%", "MTIz", pageToken{After: -1, Filter: filter}.String(), "YWJjOmRlZg"} {
+		if _, err := parsePageToken(token, filter); !errors.Is(err, ErrInvalidPageToken) {
+			t.Fatalf("%q: expected ErrInvalidPageToken, got %v", token, err)
+		}
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	var zero, small, huge uint32 = 0, 10, 100000
+	cases := map[*uint32]int{nil: defaultPageLimit, &zero: defaultPageLimit, &small: 10, &huge: maxPageLimit}
+	for limit, expected := range cases {
+		if actual := pageLimit(limit); actual != expected {
+			t.Fatalf("expected %d, got %d", expected, actual)
+		}
+	}
+}
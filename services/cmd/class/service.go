@@ -2,83 +2,154 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
 	"log/slog"
 
 	"pet/middleware/class"
-	"pet/services"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type service struct {
 	class.UnimplementedServiceServer
-	db    DatabaseClass
-	cache services.Cache
+	db           DatabaseClass
+	cache        *classCache
+	dictionaries *dictionaries
 }
 
+const maxTranslateItems = 1000
+
 func (s *service) Classes(ctx context.Context, request *class.ClassRequest) (*class.ClassReply, error) {
-	services.PrintMetadata(ctx)
+	key := s.cache.classesKey(request)
+	var reply class.ClassReply
+	if s.cache.load(ctx, "Classes", key, &reply) {
+		return &reply, nil
+	}
 	var status *string = nil
 	if request.Status != nil {
 		s2 := request.GetStatus().String()
 		status = &s2
 	}
-	classes, err := s.db.Classes(request.NameFilter, status, request.Version)
+	classes, err := s.db.Classes(ctx, request.NameFilter, status, request.Version)
 	if err != nil {
-		slog.Error("Get classes error", slog.String("err", err.Error()))
+		slog.ErrorContext(ctx, "Get classes error", slog.String("err", err.Error()))
 		return nil, err
 	}
-	var reply class.ClassReply
 	for _, element := range classes {
 		if reply.Classes == nil {
 			reply.Classes = make([]*class.Class, 0)
 		}
 		reply.Classes = append(reply.Classes, &class.Class{
-			Name:   element.Name,
-			Title:  element.Title,
-			Status: class.ClassStatusFromSql(element.Status),
+			Name:    element.Name,
+			Title:   element.Title,
+			Status:  class.ClassStatusFromSql(element.Status),
+			Version: element.Current,
+			Parent:  element.Parent,
 		})
 	}
+	s.cache.store(ctx, key, &reply)
 	return &reply, nil
 }
 
 func (s *service) Elements(ctx context.Context, request *class.ClassElementRequest) (*class.ClassElementReply, error) {
-	services.PrintMetadata(ctx)
-	c, err := s.db.Class(request.Name)
+	key := s.cache.elementsKey(request)
+	var reply class.ClassElementReply
+	if s.cache.load(ctx, "Elements", key, &reply) {
+		return &reply, nil
+	}
+	c, err := s.db.Class(ctx, request.Name)
 	if err != nil {
-		slog.Error("Get class error ", slog.String("err", err.Error()))
+		slog.ErrorContext(ctx, "Get class error ", slog.String("err", err.Error()))
 		return nil, err
 	}
-	var status *string = nil
+	if c == nil {
+		return nil, status.Errorf(codes.NotFound, "class %q not found", request.Name)
+	}
+	var itemStatus *string = nil
 	if request.Status != nil {
 		s2 := request.GetStatus().String()
-		status = &s2
+		itemStatus = &s2
 	}
-	var offset = 0
-	if request.Offset != nil {
-		offset = int(*request.Offset)
-	}
-	var limit = 100
-	if request.Limit != nil {
-		limit = int(*request.Limit)
+	filter := pageFilter(c.Name, request.Version, itemStatus, request.Subtree)
+	page, err := parsePageToken(request.GetPageToken(), filter)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	elements, next, err := s.db.Elements(*c, request.Version, status, offset, limit)
+	limit := pageLimit(request.Limit)
+	elements, more, err := s.db.Elements(ctx, *c, request.Version, itemStatus, request.Subtree, page.After, limit)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "Get elements error", slog.String("err", err.Error()))
 		return nil, err
 	}
-	var reply class.ClassElementReply
+	reply.Name = c.Name
 	for _, element := range elements {
 		if reply.Elements == nil {
 			reply.Elements = make([]*class.ClassElement, 0)
 		}
 		reply.Elements = append(reply.Elements, &class.ClassElement{
-			Key:     element.Key,
-			Value:   element.Value,
-			Status:  class.ElementStatusFromSql(element.Status),
-			Version: element.Version,
+			Key:       element.Key,
+			Value:     element.Value,
+			Status:    class.ElementStatusFromSql(element.Status),
+			Version:   element.Version,
+			ParentKey: element.ParentKey,
+		})
+	}
+	reply.Eof = !more
+	if more {
+		reply.NextPageToken = pageToken{After: elements[len(elements)-1].Next, Filter: filter}.String()
+	}
+	s.cache.store(ctx, key, &reply)
+	return &reply, nil
+}
+
+func (s *service) Lookup(ctx context.Context, request *class.LookupRequest) (*class.LookupReply, error) {
+	d, err := s.dictionaries.get(ctx, request.Name, request.Version)
+	if errors.Is(err, ErrClassNotFound) {
+		return nil, status.Errorf(codes.NotFound, "class %q not found", request.Name)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Load dictionary error", slog.String("class", request.Name), slog.String("err", err.Error()))
+		return nil, err
+	}
+	reply := class.LookupReply{Name: d.class.Name, Version: d.version}
+	for _, key := range request.Keys {
+		element, ok := d.elements[key]
+		if !ok {
+			reply.Missing = append(reply.Missing, key)
+			continue
+		}
+		reply.Elements = append(reply.Elements, &class.ClassElement{
+			Key:       element.Key,
+			Value:     element.Value,
+			Status:    class.ElementStatusFromSql(element.Status),
+			Version:   element.Version,
+			ParentKey: element.ParentKey,
 		})
 	}
-	reply.NextOffset = uint32(next)
-	reply.Eof = len(elements) < limit
+	return &reply, nil
+}
+
+func (s *service) Translate(ctx context.Context, request *class.TranslateRequest) (*class.TranslateReply, error) {
+	if len(request.Items) > maxTranslateItems {
+		return nil, status.Errorf(codes.InvalidArgument, "too many items: %d > %d", len(request.Items), maxTranslateItems)
+	}
+	reply := class.TranslateReply{Items: make([]*class.Translation, 0, len(request.Items))}
+	for _, item := range request.Items {
+		translation := &class.Translation{Name: item.Name, Key: item.Key}
+		reply.Items = append(reply.Items, translation)
+		d, err := s.dictionaries.get(ctx, item.Name, item.Version)
+		if errors.Is(err, ErrClassNotFound) {
+			continue
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Load dictionary error", slog.String("class", item.Name), slog.String("err", err.Error()))
+			return nil, err
+		}
+		translation.Version = d.version
+		if element, ok := d.elements[item.Key]; ok {
+			translation.Found = true
+			translation.Value = element.Value
+			translation.Status = class.ElementStatusFromSql(element.Status)
+		}
+	}
 	return &reply, nil
 }
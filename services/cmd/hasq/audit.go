@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"pet/services"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// AuditEvent запись журнала аудита об изменяющем вызове. Hash связывает
+// запись с PrevHash предыдущей, поэтому изменение или удаление любой записи
+// обнаруживается проверкой цепочки.
+type AuditEvent struct {
+	Id       uint64
+	Time     time.Time
+	Actor    string
+	Peer     string
+	Method   string
+	TokenId  *uuid.UUID
+	Outcome  string
+	Error    string
+	PrevHash string
+	Hash     string
+}
+
+// AuditFilter условия выборки журнала аудита. Записи возвращаются по
+// возрастанию Id, начиная со следующей после After.
+type AuditFilter struct {
+	Actor   *string
+	TokenId *uuid.UUID
+	Method  *string
+	Since   *time.Time
+	Until   *time.Time
+	After   uint64
+	Limit   int
+}
+
+// AuditCheck результат проверки цепочки журнала аудита
+type AuditCheck struct {
+	Successful bool
+	Checked    uint64
+	BrokenId   *uint64
+}
+
+// auditHash хеш записи вместе с ее Id и хешем предыдущей записи. Поля
+// экранируются, чтобы перенос символов между соседними полями менял хеш.
+func auditHash(e *AuditEvent) string {
+	var token string
+	if e.TokenId != nil {
+		token = e.TokenId.String()
+	}
+	return services.Hash(
+		strconv.FormatUint(e.Id, 10),
+		strconv.Quote(e.PrevHash),
+		strconv.Quote(e.Time.UTC().Format(time.RFC3339Nano)),
+		strconv.Quote(e.Actor),
+		strconv.Quote(e.Peer),
+		strconv.Quote(e.Method),
+		strconv.Quote(token),
+		strconv.Quote(e.Outcome),
+		strconv.Quote(e.Error),
+	)
+}
+
+// auditVerifier проверяет записи журнала в порядке Id до первой неверной
+type auditVerifier struct {
+	prev  string
+	check AuditCheck
+}
+
+func (v *auditVerifier) next(e *AuditEvent) bool {
+	if e.PrevHash != v.prev || auditHash(e) != e.Hash {
+		id := e.Id
+		v.check.BrokenId = &id
+		return false
+	}
+	v.prev = e.Hash
+	v.check.Checked++
+	return true
+}
+
+func (v *auditVerifier) result() *AuditCheck {
+	v.check.Successful = v.check.BrokenId == nil
+	return &v.check
+}
+
+// newAuditEvent запись об успешном вызове method пользователем user над
+// token. Актором считается аутентифицированный клиент, а без аутентификации
+// user из запроса.
+func newAuditEvent(ctx context.Context, method, user, token string) *AuditEvent {
+	e := &AuditEvent{
+		// Postgres хранит время с точностью до микросекунд
+		Time:    time.Now().UTC().Truncate(time.Microsecond),
+		Actor:   user,
+		Method:  method,
+		Outcome: codes.OK.String(),
+	}
+	if identity, ok := services.IdentityFrom(ctx); ok {
+		e.Actor = identity.Subject
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		e.Peer = p.Addr.String()
+	}
+	if id, parseErr := uuid.Parse(token); parseErr == nil {
+		e.TokenId = &id
+	}
+	return e
+}
+
+// auditFailure добавляет в журнал запись о неудачном вызове. Успешные
+// изменения записываются хранилищем в одной транзакции с ними, а неудачный
+// вызов ничего не изменил, поэтому ошибка записи только попадает в журнал
+// сервиса. Запись не прерывается отменой вызова клиентом.
+//
+// Журнал пишется под исключительной блокировкой, поэтому отклоненные до
+// обращения к хранилищу вызовы в него не попадают: иначе поток неверных
+// запросов растил бы журнал без ограничений и задерживал изменения.
+func (s *service) auditFailure(ctx context.Context, e *AuditEvent, err error) {
+	switch status.Code(err) {
+	case codes.OK, codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied:
+		return
+	}
+	e.Outcome = status.Code(err).String()
+	e.Error = status.Convert(err).Message()
+	if appendErr := s.db.AppendAudit(context.WithoutCancel(ctx), e); appendErr != nil {
+		slog.ErrorContext(ctx, "Can't append audit event",
+			slog.String("method", e.Method), slog.String("actor", e.Actor), slog.String("err", appendErr.Error()))
+	}
+}
+
+func auditPageToken(after uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(after, 10)))
+}
+
+func parseAuditPageToken(token string) (uint64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, ErrInvalidPageToken
+	}
+	after, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidPageToken
+	}
+	return after, nil
+}
+
+// auditLimit приводит запрошенный размер страницы к допустимому диапазону
+func auditLimit(limit *uint32) int {
+	if limit == nil || *limit == 0 {
+		return defaultAuditLimit
+	}
+	return int(min(*limit, maxAuditLimit))
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"embed"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -12,11 +12,15 @@ import (
 	"github.com/google/uuid"
 
 	"pet/services"
+	"pet/services/migrations"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 const (
+	// uniqueViolation код ошибки Postgres при нарушении уникальности
+	uniqueViolation = "23505"
+
 	sqlCreateTokenTable = `
 CREATE TABLE %s
 (
@@ -30,16 +34,33 @@ CREATE TABLE %s
 )`
 )
 
-//go:embed migrations/*.sql
-var migrations embed.FS
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenExists   = errors.New("token already exists")
+	ErrKeyNotFound   = errors.New("key not found")
+	ErrAlreadyOwned  = errors.New("token owned by this user")
+	ErrKeyMismatch   = errors.New("last user key does not match")
+)
 
+// DatabaseToken хранилище токенов. Изменяющие методы добавляют запись audit,
+// если она задана, в журнал аудита вместе с изменением, так что изменение без
+// записи не сохраняется.
 type DatabaseToken interface {
-	CreateToken(title string, data []byte) (*Token, error)
-	SearchToken(id *uuid.UUID, hash *string) (*Token, error)
-	CreateKey(user uuid.UUID, token uuid.UUID, passphrase string) (*Key, error)
-	LoadChain(token *Token) (services.Chain, error)
-	Owner(user uuid.UUID, token uuid.UUID) error
-	Validate(token uuid.UUID) (*ValidateResult, error)
+	// CreateToken создает токен, заполняя TokenId записи audit
+	CreateToken(ctx context.Context, title string, data []byte, audit *AuditEvent) (*Token, error)
+	SearchToken(ctx context.Context, id *uuid.UUID, hash *string) (*Token, error)
+	CreateKey(ctx context.Context, user uuid.UUID, token uuid.UUID, passphrase string, audit *AuditEvent) (*Key, error)
+	LoadChain(ctx context.Context, token *Token) (services.Chain, error)
+	Owner(ctx context.Context, user uuid.UUID, token uuid.UUID, audit *AuditEvent) error
+	Validate(ctx context.Context, token uuid.UUID) (*ValidateResult, error)
+	// AppendAudit добавляет запись в конец журнала аудита, заполняя Id и хеши
+	AppendAudit(ctx context.Context, event *AuditEvent) error
+	AuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+	// VerifyAudit проверяет цепочку хешей всего журнала аудита
+	VerifyAudit(ctx context.Context) (*AuditCheck, error)
+	services.OutboxStore
+	Ping(ctx context.Context) error
+	Close() error
 }
 
 type Token struct {
@@ -72,12 +93,21 @@ type ds struct {
 	db *sql.DB
 }
 
-func (d *ds) Validate(token uuid.UUID) (*ValidateResult, error) {
-	t, err := d.SearchToken(&token, nil)
+func (d *ds) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func (d *ds) Close() error {
+	slog.Info("Database pool closed", slog.Any("pool", services.DBStatsValue(d.db)))
+	return d.db.Close()
+}
+
+func (d *ds) Validate(ctx context.Context, token uuid.UUID) (*ValidateResult, error) {
+	t, err := d.SearchToken(ctx, &token, nil)
 	if err != nil {
-		return nil, errors.New("token not found")
+		return nil, ErrTokenNotFound
 	}
-	c, err := d.LoadChain(t)
+	c, err := d.LoadChain(ctx, t)
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +117,7 @@ func (d *ds) Validate(token uuid.UUID) (*ValidateResult, error) {
 	if v {
 		lastNum, k := c.GetOwner()
 		if k != nil {
-			err = d.db.QueryRow("SELECT user_id FROM keys WHERE hash = $1", k.String()).Scan(&userId)
+			err = d.db.QueryRowContext(ctx, "SELECT user_id FROM keys WHERE hash = $1", k.String()).Scan(&userId)
 			if err != nil {
 				return nil, err
 			}
@@ -101,16 +131,16 @@ func (d *ds) Validate(token uuid.UUID) (*ValidateResult, error) {
 	}, nil
 }
 
-func (d *ds) Owner(user uuid.UUID, token uuid.UUID) error {
-	t, err := d.SearchToken(&token, nil)
+func (d *ds) Owner(ctx context.Context, user uuid.UUID, token uuid.UUID, audit *AuditEvent) error {
+	t, err := d.SearchToken(ctx, &token, nil)
 	if err != nil {
-		return errors.New("token not found")
+		return ErrTokenNotFound
 	}
-	c, err := d.LoadChain(t)
+	c, err := d.LoadChain(ctx, t)
 	if err != nil {
 		return err
 	}
-	k, err := d.loadLastKey(user, token)
+	k, err := d.loadLastKey(ctx, user, token)
 	if err != nil {
 		return err
 	}
@@ -118,33 +148,43 @@ func (d *ds) Owner(user uuid.UUID, token uuid.UUID) error {
 	lastNum, key := c.GetOwner()
 	if lastNum > 0 {
 		if k.Hash == key.String() {
-			return errors.New("token owned by this user")
+			return ErrAlreadyOwned
 		} else if k.Num != lastNum+1 {
-			return errors.New("last user key does not match")
+			return ErrKeyMismatch
 		}
 	}
 	owned := c.Owned(lk)
-	tx, err := d.db.Begin()
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = tx.Rollback() }()
 	tb := tableName(token)
-	_, err = tx.Exec("INSERT INTO "+tb+"(id, key) VALUES ($1, $2)", k.Num, k.Hash)
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+tb+"(id, key) VALUES ($1, $2)", k.Num, k.Hash)
 	if err != nil {
 		return err
 	}
 	if owned.Gen != nil {
-		_, err = tx.Exec("UPDATE "+tb+" SET generator = $1 WHERE id = $2", *owned.Gen, owned.GenId)
+		_, err = tx.ExecContext(ctx, "UPDATE "+tb+" SET generator = $1 WHERE id = $2", *owned.Gen, owned.GenId)
 		if err != nil {
 			return err
 		}
 	}
 	if owned.Own != nil {
-		_, err = tx.Exec("UPDATE "+tb+" SET owner = $1 WHERE id = $2", *owned.Own, owned.OwnId)
+		_, err = tx.ExecContext(ctx, "UPDATE "+tb+" SET owner = $1 WHERE id = $2", *owned.Own, owned.OwnId)
 		if err != nil {
 			return err
 		}
 	}
+	err = services.WriteOutbox(ctx, tx, services.EventOwnershipTransferred, token.String(), services.OwnershipTransferred{
+		TokenId: token.String(), UserId: user.String(), KeyHash: k.Hash, Num: k.Num,
+	})
+	if err != nil {
+		return err
+	}
+	if err = appendAudit(ctx, tx, audit); err != nil {
+		return err
+	}
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -152,15 +192,17 @@ func (d *ds) Owner(user uuid.UUID, token uuid.UUID) error {
 	return nil
 }
 
-func (d *ds) loadLastKey(user uuid.UUID, token uuid.UUID) (*Key, error) {
+func (d *ds) loadLastKey(ctx context.Context, user uuid.UUID, token uuid.UUID) (*Key, error) {
 	var id uuid.UUID
 	var hash string
 	var num uint64
 
-	slog.Debug("Last key searching", slog.String("token", token.String()), slog.String("user", user.String()))
-	err := d.db.QueryRow("SELECT id, hash, num FROM keys WHERE user_id = $1 AND token_id = $2 ORDER BY num DESC LIMIT 1", user, token).
+	slog.DebugContext(ctx, "Last key searching", slog.String("token", token.String()), slog.String("user", user.String()))
+	err := d.db.QueryRowContext(ctx, "SELECT id, hash, num FROM keys WHERE user_id = $1 AND token_id = $2 ORDER BY num DESC LIMIT 1", user, token).
 		Scan(&id, &hash, &num)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
 		return nil, err
 	}
 	return &Key{
@@ -171,16 +213,16 @@ func (d *ds) loadLastKey(user uuid.UUID, token uuid.UUID) (*Key, error) {
 	}, nil
 }
 
-func (d *ds) LoadChain(token *Token) (services.Chain, error) {
+func (d *ds) LoadChain(ctx context.Context, token *Token) (services.Chain, error) {
 	var c uint64
 	tb := tableName(token.Id)
 	query := fmt.Sprintf("SELECT COUNT(id) FROM %s", tb)
-	err := d.db.QueryRow(query).Scan(&c)
+	err := d.db.QueryRowContext(ctx, query).Scan(&c)
 	if err != nil {
 		return nil, err
 	}
 	query = fmt.Sprintf("SELECT id, key, generator, owner FROM %s ORDER BY id LIMIT 4", tb)
-	rows, err := d.db.Query(query)
+	rows, err := d.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -202,12 +244,12 @@ func (d *ds) LoadChain(token *Token) (services.Chain, error) {
 	return nil, errors.New("chain damaged")
 }
 
-func (d *ds) CreateKey(user uuid.UUID, token uuid.UUID, passphrase string) (*Key, error) {
-	t, err := d.SearchToken(&token, nil)
+func (d *ds) CreateKey(ctx context.Context, user uuid.UUID, token uuid.UUID, passphrase string, audit *AuditEvent) (*Key, error) {
+	t, err := d.SearchToken(ctx, &token, nil)
 	if err != nil {
-		return nil, errors.New("token not found")
+		return nil, ErrTokenNotFound
 	}
-	c, err := d.LoadChain(t)
+	c, err := d.LoadChain(ctx, t)
 	if err != nil {
 		return nil, err
 	}
@@ -218,16 +260,24 @@ func (d *ds) CreateKey(user uuid.UUID, token uuid.UUID, passphrase string) (*Key
 		n = n + 1
 	}
 	n, k = c.KeyOn(n, passphrase)
-	rows := d.db.QueryRow("INSERT INTO keys(hash, num, token_id, user_id) VALUES($1, $2, $3, $4) RETURNING id",
-		k.String(), n, token, user)
-	if rows.Err() != nil {
-		return nil, rows.Err()
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = tx.Rollback() }()
 	var keyId uuid.UUID
-	if err = rows.Scan(&keyId); err != nil {
+	err = tx.QueryRowContext(ctx, "INSERT INTO keys(hash, num, token_id, user_id) VALUES($1, $2, $3, $4) RETURNING id",
+		k.String(), n, token, user).Scan(&keyId)
+	if err != nil {
 		return nil, err
 	}
-	slog.Debug("Key created",
+	if err = appendAudit(ctx, tx, audit); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	slog.DebugContext(ctx, "Key created",
 		slog.String("key_id", keyId.String()),
 		slog.String("key_hash", k.String()),
 		slog.String("token_id", token.String()),
@@ -256,12 +306,12 @@ func textOrUndefined(id interface{}) string {
 	return "undefined"
 }
 
-func (d *ds) SearchToken(id *uuid.UUID, hash *string) (*Token, error) {
+func (d *ds) SearchToken(ctx context.Context, id *uuid.UUID, hash *string) (*Token, error) {
 	var rows *sql.Row
 	if hash != nil {
-		rows = d.db.QueryRow("SELECT id, title, hash, data FROM tokens WHERE hash = $1", *hash)
+		rows = d.db.QueryRowContext(ctx, "SELECT id, title, hash, data FROM tokens WHERE hash = $1", *hash)
 	} else if id != nil {
-		rows = d.db.QueryRow("SELECT id, title, hash, data FROM tokens WHERE id = $1", id.String())
+		rows = d.db.QueryRowContext(ctx, "SELECT id, title, hash, data FROM tokens WHERE id = $1", id.String())
 	} else {
 		return nil, errors.New("no token found")
 	}
@@ -270,40 +320,57 @@ func (d *ds) SearchToken(id *uuid.UUID, hash *string) (*Token, error) {
 	}
 	var token Token
 	if err := rows.Scan(&token.Id, &token.Title, &token.Hash, &token.Data); err != nil {
-		slog.Debug("Token not found",
+		slog.DebugContext(ctx, "Token not found",
 			slog.String("search_id", textOrUndefined(id)),
 			slog.String("search_hash", textOrUndefined(hash)))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
 		return nil, err
 	}
-	slog.Debug("Token searched", slog.String("token", token.String()))
+	slog.DebugContext(ctx, "Token searched", slog.String("token", token.String()))
 	return &token, nil
 }
 
-func (d *ds) CreateToken(title string, data []byte) (*Token, error) {
+func (d *ds) CreateToken(ctx context.Context, title string, data []byte, audit *AuditEvent) (*Token, error) {
 	token := services.CreateToken(data)
-	tx, err := d.db.Begin()
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	row := tx.QueryRow(
+	defer func() { _ = tx.Rollback() }()
+	row := tx.QueryRowContext(ctx,
 		"INSERT INTO tokens(title, hash, data) VALUES ($1, $2, $3) RETURNING id", title, token.String(), data)
-	if row.Err() != nil {
-		return nil, row.Err()
-	}
 	var tokenId uuid.UUID
-	if err = row.Scan(&tokenId); err != nil {
+	err = row.Scan(&tokenId)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return nil, fmt.Errorf("%w: %s", ErrTokenExists, token.String())
+	} else if err != nil {
 		return nil, err
 	}
 	tb := tableName(tokenId)
-	_, err = tx.Exec(fmt.Sprintf(sqlCreateTokenTable, tb))
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(sqlCreateTokenTable, tb))
+	if err != nil {
+		return nil, err
+	}
+	err = services.WriteOutbox(ctx, tx, services.EventTokenCreated, tokenId.String(), services.TokenCreated{
+		TokenId: tokenId.String(), Title: title, Hash: token.String(),
+	})
 	if err != nil {
 		return nil, err
 	}
+	if audit != nil {
+		audit.TokenId = &tokenId
+	}
+	if err = appendAudit(ctx, tx, audit); err != nil {
+		return nil, err
+	}
 	err = tx.Commit()
 	if err != nil {
 		return nil, err
 	}
-	slog.Debug("Token created",
+	slog.DebugContext(ctx, "Token created",
 		slog.String("token_id", tokenId.String()), slog.String("hash", token.String()))
 	return &Token{
 		Id:    tokenId,
@@ -313,16 +380,139 @@ func (d *ds) CreateToken(title string, data []byte) (*Token, error) {
 	}, err
 }
 
+func (d *ds) AppendAudit(ctx context.Context, event *AuditEvent) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err = appendAudit(ctx, tx, event); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appendAudit добавляет event в журнал аудита в транзакции tx. Таблица
+// блокируется до конца транзакции, поэтому вызывается последним перед
+// фиксацией.
+func appendAudit(ctx context.Context, tx *sql.Tx, event *AuditEvent) error {
+	if event == nil {
+		return nil
+	}
+	// Записи добавляются по одной, чтобы у каждой был свой предыдущий хеш
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE audit_events IN EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	var prev string
+	err := tx.QueryRowContext(ctx, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	event.PrevHash = prev
+	// Id входит в хеш, поэтому выдается до вставки
+	err = tx.QueryRowContext(ctx, "SELECT nextval(pg_get_serial_sequence('audit_events', 'id'))").Scan(&event.Id)
+	if err != nil {
+		return err
+	}
+	event.Hash = auditHash(event)
+	_, err = tx.ExecContext(ctx, `INSERT INTO audit_events(id, created_at, actor, peer, method, token_id, outcome, error, prev_hash, hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		event.Id, event.Time, event.Actor, event.Peer, event.Method, event.TokenId, event.Outcome, event.Error, event.PrevHash, event.Hash)
+	return err
+}
+
+const sqlSelectAudit = "SELECT id, created_at, actor, peer, method, token_id, outcome, error, prev_hash, hash FROM audit_events"
+
+func scanAudit(rows *sql.Rows) (*AuditEvent, error) {
+	var e AuditEvent
+	var token uuid.NullUUID
+	err := rows.Scan(&e.Id, &e.Time, &e.Actor, &e.Peer, &e.Method, &token, &e.Outcome, &e.Error, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if token.Valid {
+		e.TokenId = &token.UUID
+	}
+	return &e, nil
+}
+
+func (d *ds) AuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	args := []any{filter.After}
+	where := []string{"id > $1"}
+	add := func(condition string, value any) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Actor != nil {
+		add("actor = $%d", *filter.Actor)
+	}
+	if filter.TokenId != nil {
+		add("token_id = $%d", *filter.TokenId)
+	}
+	if filter.Method != nil {
+		add("method = $%d", *filter.Method)
+	}
+	if filter.Since != nil {
+		add("created_at >= $%d", *filter.Since)
+	}
+	if filter.Until != nil {
+		add("created_at < $%d", *filter.Until)
+	}
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf("%s WHERE %s ORDER BY id LIMIT $%d", sqlSelectAudit, strings.Join(where, " AND "), len(args))
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var events []*AuditEvent
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (d *ds) VerifyAudit(ctx context.Context) (*AuditCheck, error) {
+	rows, err := d.db.QueryContext(ctx, sqlSelectAudit+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var v auditVerifier
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return nil, err
+		}
+		if !v.next(e) {
+			break
+		}
+	}
+	return v.result(), rows.Err()
+}
+
+func (d *ds) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, services.OutboxEvent) error) (int, error) {
+	return services.RelayOutbox(ctx, d.db, limit, publish)
+}
+
 func tableName(tokenId uuid.UUID) string {
 	tb := "token_" + strings.Replace(tokenId.String(), "-", "", -1)
 	tb = strings.ToLower(tb)
 	return tb
 }
 
-func NewDatabaseToken() DatabaseToken {
-	db, err := services.NewDatabase(migrations)
+func NewDatabaseToken(ctx context.Context, cfg *services.Config) (DatabaseToken, error) {
+	if cfg.Database.InMemory() {
+		slog.Warn("Data is kept in memory and will be lost on restart")
+		return NewMemoryDatabaseToken(), nil
+	}
+	db, err := services.NewDatabase(ctx, cfg, migrations.Hasq)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &ds{db}
+	return &ds{db}, nil
 }
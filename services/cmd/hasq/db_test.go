@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pet/services"
+	"pet/services/testkit"
+)
+
+// testDatabase открывает хранилище токенов в отдельной схеме локального Postgres
+func testDatabase(t *testing.T) DatabaseToken {
+	t.Helper()
+	d, err := NewDatabaseToken(context.Background(), testkit.Config(t, "hasq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+	return d
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(testkit.Run(m))
+}
+
+// forEachDatabase выполняет test для хранилища в памяти и в Postgres
+func forEachDatabase(t *testing.T, test func(t *testing.T, d DatabaseToken)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryDatabaseToken())
+	})
+	t.Run("postgres", func(t *testing.T) {
+		test(t, testDatabase(t))
+	})
+}
+
+func TestDatabaseToken_SearchToken(t *testing.T) {
+	forEachDatabase(t, testSearchToken)
+}
+
+func testSearchToken(t *testing.T, d DatabaseToken) {
+	ctx := context.Background()
+	created, err := d.CreateToken(ctx, "Token", []byte("DATA"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byId, err := d.SearchToken(ctx, &created.Id, nil)
+	if err != nil || byId.Hash != created.Hash || string(byId.Data) != "DATA" {
+		t.Fatalf("token by id expected, got %v, %v", byId, err)
+	}
+	byHash, err := d.SearchToken(ctx, nil, &created.Hash)
+	if err != nil || byHash.Id != created.Id {
+		t.Fatalf("token by hash expected, got %v, %v", byHash, err)
+	}
+	if _, err = d.CreateToken(ctx, "Duplicate", []byte("DATA"), nil); err == nil {
+		t.Fatal("token with the same data must be rejected")
+	}
+	missing := uuid.New()
+	if _, err = d.SearchToken(ctx, &missing, nil); err == nil {
+		t.Fatal("missing token found")
+	}
+}
+
+func TestDatabaseToken_Ownership(t *testing.T) {
+	forEachDatabase(t, testOwnership)
+}
+
+func testOwnership(t *testing.T, d DatabaseToken) {
+	ctx := context.Background()
+	token, err := d.CreateToken(ctx, "Token", []byte("OWNED"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := d.Validate(ctx, token.Id); err != nil || !result.Successful || result.LastNum != 0 {
+		t.Fatalf("empty chain must be valid without owner, got %+v, %v", result, err)
+	}
+
+	for i, user := range []uuid.UUID{uuid.New(), uuid.New(), uuid.New()} {
+		key, err := d.CreateKey(ctx, user, token.Id, "passphrase", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.Num != uint64(i+1) {
+			t.Fatalf("key %d expected, got %d", i+1, key.Num)
+		}
+		if err = d.Owner(ctx, user, token.Id, nil); err != nil {
+			t.Fatal(err)
+		}
+		result, err := d.Validate(ctx, token.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Successful || result.OwnerId != user || result.LastNum != key.Num {
+			t.Fatalf("user %s must own the token, got %+v", user, result)
+		}
+		if err = d.Owner(ctx, user, token.Id, nil); err == nil {
+			t.Fatal("token owned twice by the same user")
+		}
+	}
+}
+
+func TestDatabaseToken_KeyWithoutToken(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, d DatabaseToken) {
+		ctx := context.Background()
+		if _, err := d.CreateKey(ctx, uuid.New(), uuid.New(), "passphrase", nil); err == nil {
+			t.Fatal("key created for a missing token")
+		}
+		token, err := d.CreateToken(ctx, "Token", []byte("KEYLESS"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = d.Owner(ctx, uuid.New(), token.Id, nil); err == nil {
+			t.Fatal("token owned without a key")
+		}
+	})
+}
+
+func TestDatabaseToken_Outbox(t *testing.T) {
+	forEachDatabase(t, testOutbox)
+}
+
+func testOutbox(t *testing.T, d DatabaseToken) {
+	ctx := context.Background()
+	token, err := d.CreateToken(ctx, "Token", []byte("EVENTS"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := uuid.New()
+	key, err := d.CreateKey(ctx, user, token.Id, "passphrase", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Owner(ctx, user, token.Id, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Неудачная передача владения не пишет событие
+	_ = d.Owner(ctx, user, token.Id, nil)
+
+	var events []services.OutboxEvent
+	collect := func(_ context.Context, e services.OutboxEvent) error {
+		if e.Aggregate == token.Id.String() {
+			events = append(events, e)
+		}
+		return nil
+	}
+	if _, err = d.RelayOutbox(ctx, 100, collect); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != services.EventTokenCreated || events[1].Type != services.EventOwnershipTransferred {
+		t.Fatalf("token created and ownership transferred expected, got %+v", events)
+	}
+	var owned services.OwnershipTransferred
+	if err = json.Unmarshal(events[1].Payload, &owned); err != nil {
+		t.Fatal(err)
+	}
+	if owned.UserId != user.String() || owned.KeyHash != key.Hash || owned.Num != key.Num {
+		t.Fatalf("ownership of key %+v expected, got %+v", key, owned)
+	}
+	if n, err := d.RelayOutbox(ctx, 100, collect); err != nil || n != 0 {
+		t.Fatalf("published events must not be relayed again, got %d, %v", n, err)
+	}
+}
+
+func TestDatabaseToken_Audit(t *testing.T) {
+	forEachDatabase(t, testAudit)
+}
+
+func testAudit(t *testing.T, d DatabaseToken) {
+	ctx := context.Background()
+	token := uuid.New()
+	for _, actor := range []string{"u1", "u2", "u1"} {
+		e := &AuditEvent{Time: time.Now().UTC().Truncate(time.Microsecond), Actor: actor, Method: "/hasq.Service/Owned",
+			TokenId: &token, Outcome: "OK"}
+		if err := d.AppendAudit(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Id == 0 || e.Hash == "" {
+			t.Fatalf("stored event expected, got %+v", e)
+		}
+	}
+	actor := "u1"
+	events, err := d.AuditEvents(ctx, AuditFilter{Actor: &actor, Limit: 10})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("2 events of u1 expected, got %v, %v", events, err)
+	}
+	if events[1].PrevHash == events[0].Hash {
+		t.Fatal("events of u1 are not adjacent in the log")
+	}
+	if tail, _ := d.AuditEvents(ctx, AuditFilter{TokenId: &token, After: events[0].Id, Limit: 1}); len(tail) != 1 || tail[0].Actor != "u2" {
+		t.Fatalf("second event expected after the first, got %v", tail)
+	}
+	check, err := d.VerifyAudit(ctx)
+	if err != nil || !check.Successful || check.Checked != 3 {
+		t.Fatalf("valid log of 3 events expected, got %+v, %v", check, err)
+	}
+}
+
+func TestDatabaseToken_AuditWithChange(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, d DatabaseToken) {
+		ctx := context.Background()
+		actor := uuid.NewString()
+		event := func(method string) *AuditEvent {
+			return &AuditEvent{Time: time.Now().UTC().Truncate(time.Microsecond), Actor: actor, Method: method, Outcome: "OK"}
+		}
+		created := event("/hasq.Service/CreateToken")
+		token, err := d.CreateToken(ctx, "Token", []byte("AUDITED"), created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.Id == 0 || created.TokenId == nil || *created.TokenId != token.Id {
+			t.Fatalf("event of the created token expected, got %+v", created)
+		}
+		user := uuid.New()
+		if _, err = d.CreateKey(ctx, user, token.Id, "passphrase", event("/hasq.Service/CreateKey")); err != nil {
+			t.Fatal(err)
+		}
+		if err = d.Owner(ctx, user, token.Id, event("/hasq.Service/Owned")); err != nil {
+			t.Fatal(err)
+		}
+		// Неудачное изменение не добавляет запись
+		if err = d.Owner(ctx, user, token.Id, event("/hasq.Service/Owned")); err == nil {
+			t.Fatal("token owned twice by the same user")
+		}
+		events, err := d.AuditEvents(ctx, AuditFilter{Actor: &actor, Limit: 10})
+		if err != nil || len(events) != 3 {
+			t.Fatalf("3 events of the actor expected, got %v, %v", events, err)
+		}
+		if check, err := d.VerifyAudit(ctx); err != nil || !check.Successful {
+			t.Fatalf("valid log expected, got %+v, %v", check, err)
+		}
+	})
+}
+
+func TestDatabaseToken_AuditAppendOnly(t *testing.T) {
+	cfg := testkit.Config(t, "hasq")
+	d, err := NewDatabaseToken(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+	ctx := context.Background()
+	if err = d.AppendAudit(ctx, &AuditEvent{Time: time.Now(), Actor: "u1", Method: "m", Outcome: "OK"}); err != nil {
+		t.Fatal(err)
+	}
+	db := d.(*ds).db
+	for _, query := range []string{
+		"UPDATE audit_events SET actor = 'u2'",
+		"DELETE FROM audit_events",
+		"TRUNCATE audit_events",
+	} {
+		if _, err = db.ExecContext(ctx, query); err == nil {
+			t.Fatalf("%s must fail", query)
+		}
+	}
+}
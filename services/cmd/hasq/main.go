@@ -1,55 +1,76 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net"
 	"os"
-	"runtime/debug"
 
 	"pet/middleware/hasq"
 	"pet/services"
-
-	"github.com/joho/godotenv"
-	"google.golang.org/grpc"
-)
-
-var (
-	port = flag.Int("port", 52051, "The service port")
 )
 
 func main() {
-	defer func() {
-		if err := recover(); err != nil {
-			slog.Error("Recovered from panic",
-				slog.String("stack", string(debug.Stack())),
-				slog.String("err", fmt.Sprintf("%v", err)))
-		}
-	}()
-	_ = services.ExitHandle(func(context.Context) {
-		slog.Info("Service exit")
-		os.Exit(0)
-	})
-	flag.Parse()
-	services.DefineLogging()
-	services.DefineMetrics()
-	err := godotenv.Load(".env", ".env.local")
+	cfg, err := services.LoadConfig("hasq", 52051)
 	if err != nil {
-		slog.Warn("Warning loading .env file", slog.String("err", err.Error()))
+		slog.Error("Invalid configuration", slog.String("err", err.Error()))
+		os.Exit(2)
+	}
+	if flag.Arg(0) == "probe" {
+		os.Exit(services.Probe(cfg))
 	}
-	listen, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	lc := services.NewLifecycle(cfg.ShutdownTimeout)
+	ctx := lc.Context()
+	if _, err = services.DefineLogging(lc, cfg); err != nil {
+		slog.Error("Can't define logging", slog.String("err", err.Error()))
+		os.Exit(2)
+	}
+	mux := services.DefineMetrics(lc, cfg)
+	if err = services.DefineTracing(lc, cfg); err != nil {
+		slog.Error("Can't define tracing", slog.String("err", err.Error()))
+	}
+	slog.Info("Configuration loaded", slog.Any("config", cfg))
+	listen, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
 	if err != nil {
 		slog.Error("Failed to listen", slog.String("err", err.Error()))
 		return
 	}
-	grpcServer := grpc.NewServer()
-	db := NewDatabaseToken()
+	limiter, err := services.NewRateLimiter(cfg)
+	if err != nil {
+		slog.Error("Can't create rate limiter", slog.String("err", err.Error()))
+		lc.Stop()
+		_ = lc.Wait()
+		os.Exit(2)
+	}
+	lc.OnStop(services.PhaseClose, "ratelimit", services.Closer(limiter))
+	grpcServer, err := services.NewGRPCServer(cfg, limiter)
+	if err != nil {
+		slog.Error("Can't create grpc server", slog.String("err", err.Error()))
+		lc.Stop()
+		_ = lc.Wait()
+		os.Exit(2)
+	}
+	db, err := NewDatabaseToken(ctx, cfg)
+	if err != nil {
+		slog.Error("Can't open database", slog.String("err", err.Error()))
+		lc.Stop()
+		_ = lc.Wait()
+		os.Exit(1)
+	}
+	lc.OnStop(services.PhaseClose, "database", services.Closer(db))
+	if err = services.DefineOutbox(lc, cfg, db); err != nil {
+		slog.Error("Can't define outbox relay", slog.String("err", err.Error()))
+		lc.Stop()
+		_ = lc.Wait()
+		os.Exit(2)
+	}
 	server := &service{db: db}
 	hasq.RegisterServiceServer(grpcServer, server)
-	slog.Info("Starting server", slog.String("addr", listen.Addr().String()))
-	if err = grpcServer.Serve(listen); err != nil {
-		slog.Error("Failed to serve ", slog.String("err", err.Error()))
+	health := services.RegisterHealth(lc, grpcServer, mux)
+	health.AddCheck("postgres", db.Ping)
+	services.ServeGRPC(lc, grpcServer, listen)
+	if err = lc.Wait(); err != nil {
+		os.Exit(1)
 	}
 }
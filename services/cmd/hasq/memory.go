@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"pet/services"
+)
+
+// memoryLink строка таблицы цепочки токена
+type memoryLink struct {
+	id        uint64
+	key       string
+	generator *string
+	owner     *string
+}
+
+type memoryKey struct {
+	Key
+	tokenId uuid.UUID
+}
+
+// memoryDatabase хранит токены в памяти процесса по правилам схемы Postgres:
+// уникальные хеши токенов и ключей, сквозная нумерация ключей токена и
+// цепочки владения, ссылающиеся на созданные ключи
+type memoryDatabase struct {
+	mu     sync.RWMutex
+	tokens map[uuid.UUID]*Token
+	hashes map[string]uuid.UUID
+	keys   map[string]*memoryKey
+	chains map[uuid.UUID][]*memoryLink
+	audit  []*AuditEvent
+	services.MemoryOutbox
+}
+
+// NewMemoryDatabaseToken создает пустое хранилище токенов в памяти
+func NewMemoryDatabaseToken() DatabaseToken {
+	return &memoryDatabase{
+		tokens: make(map[uuid.UUID]*Token),
+		hashes: make(map[string]uuid.UUID),
+		keys:   make(map[string]*memoryKey),
+		chains: make(map[uuid.UUID][]*memoryLink),
+	}
+}
+
+func (d *memoryDatabase) CreateToken(_ context.Context, title string, data []byte, audit *AuditEvent) (*Token, error) {
+	hash := services.CreateToken(data).String()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.hashes[hash]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrTokenExists, hash)
+	}
+	token := &Token{Id: uuid.New(), Title: title, Hash: hash, Data: data}
+	d.tokens[token.Id] = token
+	d.hashes[hash] = token.Id
+	d.chains[token.Id] = nil
+	d.Write(services.EventTokenCreated, token.Id.String(), services.TokenCreated{
+		TokenId: token.Id.String(), Title: title, Hash: hash,
+	})
+	if audit != nil {
+		audit.TokenId = &token.Id
+		d.appendAudit(audit)
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (d *memoryDatabase) SearchToken(_ context.Context, id *uuid.UUID, hash *string) (*Token, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.searchToken(id, hash)
+}
+
+func (d *memoryDatabase) searchToken(id *uuid.UUID, hash *string) (*Token, error) {
+	if hash != nil {
+		found, ok := d.hashes[*hash]
+		if !ok {
+			return nil, ErrTokenNotFound
+		}
+		id = &found
+	} else if id == nil {
+		return nil, errors.New("no token found")
+	}
+	token, ok := d.tokens[*id]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (d *memoryDatabase) LoadChain(_ context.Context, token *Token) (services.Chain, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.loadChain(token)
+}
+
+func (d *memoryDatabase) loadChain(token *Token) (services.Chain, error) {
+	links, ok := d.chains[token.Id]
+	if !ok {
+		return nil, fmt.Errorf("chain of token %s not found", token.Id)
+	}
+	ch := services.CreateEmptyChain(token.Hash, uint64(len(links)))
+	for _, link := range links {
+		_ = ch.Push(link.id, link.key, link.generator, link.owner)
+	}
+	if !ch.Validate() {
+		return nil, errors.New("chain damaged")
+	}
+	return ch, nil
+}
+
+func (d *memoryDatabase) CreateKey(_ context.Context, user uuid.UUID, token uuid.UUID, passphrase string, audit *AuditEvent) (*Key, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, err := d.searchToken(&token, nil)
+	if err != nil {
+		return nil, ErrTokenNotFound
+	}
+	c, err := d.loadChain(t)
+	if err != nil {
+		return nil, err
+	}
+	n, k := c.GetOwner()
+	if k == nil {
+		n = 1
+	} else {
+		n = n + 1
+	}
+	n, k = c.KeyOn(n, passphrase)
+	if _, ok := d.keys[k.String()]; ok {
+		return nil, fmt.Errorf("key %s already exists", k.String())
+	}
+	for _, existing := range d.keys {
+		if existing.Num == n && existing.tokenId == token && existing.UserId == user {
+			return nil, fmt.Errorf("key %d of token %s already exists for user %s", n, token, user)
+		}
+	}
+	key := Key{Id: uuid.New(), Hash: k.String(), Num: n, UserId: user}
+	d.keys[key.Hash] = &memoryKey{Key: key, tokenId: token}
+	if audit != nil {
+		d.appendAudit(audit)
+	}
+	return &key, nil
+}
+
+// lastKey последний по номеру ключ пользователя user для токена token
+func (d *memoryDatabase) lastKey(user uuid.UUID, token uuid.UUID) (*Key, error) {
+	var last *Key
+	for _, k := range d.keys {
+		if k.UserId == user && k.tokenId == token && (last == nil || k.Num > last.Num) {
+			last = &k.Key
+		}
+	}
+	if last == nil {
+		return nil, ErrKeyNotFound
+	}
+	copied := *last
+	return &copied, nil
+}
+
+func (d *memoryDatabase) Owner(_ context.Context, user uuid.UUID, token uuid.UUID, audit *AuditEvent) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, err := d.searchToken(&token, nil)
+	if err != nil {
+		return ErrTokenNotFound
+	}
+	c, err := d.loadChain(t)
+	if err != nil {
+		return err
+	}
+	k, err := d.lastKey(user, token)
+	if err != nil {
+		return err
+	}
+	lastNum, key := c.GetOwner()
+	if lastNum > 0 {
+		if k.Hash == key.String() {
+			return ErrAlreadyOwned
+		} else if k.Num != lastNum+1 {
+			return ErrKeyMismatch
+		}
+	}
+	owned := c.Owned(services.LoadKey(k.Hash))
+	links := d.chains[token]
+	for _, link := range links {
+		if link.id == k.Num {
+			return fmt.Errorf("link %d of token %s already exists", k.Num, token)
+		}
+	}
+	links = append(links, &memoryLink{id: k.Num, key: k.Hash})
+	for _, link := range links {
+		if owned.Gen != nil && link.id == owned.GenId {
+			link.generator = owned.Gen
+		}
+		if owned.Own != nil && link.id == owned.OwnId {
+			link.owner = owned.Own
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].id < links[j].id })
+	d.chains[token] = links
+	d.Write(services.EventOwnershipTransferred, token.String(), services.OwnershipTransferred{
+		TokenId: token.String(), UserId: user.String(), KeyHash: k.Hash, Num: k.Num,
+	})
+	if audit != nil {
+		d.appendAudit(audit)
+	}
+	return nil
+}
+
+func (d *memoryDatabase) Validate(_ context.Context, token uuid.UUID) (*ValidateResult, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	t, err := d.searchToken(&token, nil)
+	if err != nil {
+		return nil, ErrTokenNotFound
+	}
+	c, err := d.loadChain(t)
+	if err != nil {
+		return nil, err
+	}
+	result := &ValidateResult{Successful: c.Validate()}
+	if result.Successful {
+		lastNum, k := c.GetOwner()
+		if k != nil {
+			owner, ok := d.keys[k.String()]
+			if !ok {
+				return nil, ErrKeyNotFound
+			}
+			result.OwnerId = owner.UserId
+			result.LastNum = lastNum
+		}
+	}
+	return result, nil
+}
+
+func (d *memoryDatabase) AppendAudit(_ context.Context, event *AuditEvent) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.appendAudit(event)
+	return nil
+}
+
+// appendAudit добавляет event в журнал под блокировкой хранилища
+func (d *memoryDatabase) appendAudit(event *AuditEvent) {
+	event.Id = uint64(len(d.audit)) + 1
+	event.PrevHash = ""
+	if len(d.audit) > 0 {
+		event.PrevHash = d.audit[len(d.audit)-1].Hash
+	}
+	event.Hash = auditHash(event)
+	stored := *event
+	d.audit = append(d.audit, &stored)
+}
+
+func (d *memoryDatabase) AuditEvents(_ context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var events []*AuditEvent
+	for _, e := range d.audit {
+		if len(events) == filter.Limit {
+			break
+		}
+		if e.Id <= filter.After ||
+			filter.Actor != nil && e.Actor != *filter.Actor ||
+			filter.TokenId != nil && (e.TokenId == nil || *e.TokenId != *filter.TokenId) ||
+			filter.Method != nil && e.Method != *filter.Method ||
+			filter.Since != nil && e.Time.Before(*filter.Since) ||
+			filter.Until != nil && !e.Time.Before(*filter.Until) {
+			continue
+		}
+		copied := *e
+		events = append(events, &copied)
+	}
+	return events, nil
+}
+
+func (d *memoryDatabase) VerifyAudit(context.Context) (*AuditCheck, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var v auditVerifier
+	for _, e := range d.audit {
+		if !v.next(e) {
+			break
+		}
+	}
+	return v.result(), nil
+}
+
+func (d *memoryDatabase) Ping(context.Context) error {
+	return nil
+}
+
+func (d *memoryDatabase) Close() error {
+	return nil
+}
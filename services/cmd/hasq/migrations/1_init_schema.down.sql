@@ -1 +0,0 @@
-DROP TABLE token;
\ No newline at end of file
@@ -2,27 +2,55 @@ package main
 
 import (
 	"context"
+	"errors"
 
 	"pet/middleware/hasq"
+	"pet/services"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// statusError переводит ошибки хранилища в коды gRPC, по которым клиенты
+// различают их без разбора текста
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, ErrTokenNotFound), errors.Is(err, ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrTokenExists), errors.Is(err, ErrAlreadyOwned):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrKeyMismatch):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrInvalidPageToken):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return err
+}
+
+// parseId разбирает идентификатор name из запроса
+func parseId(name, value string) (uuid.UUID, error) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, status.Errorf(codes.InvalidArgument, "invalid %s %q", name, value)
+	}
+	return id, nil
+}
+
 type service struct {
 	hasq.UnimplementedServiceServer
 	db DatabaseToken
 }
 
-func (s *service) Validate(_ context.Context, cv *hasq.ChainValidate) (*hasq.ChainValidateReply, error) {
-	tokenId, err := uuid.Parse(cv.TokenId)
+func (s *service) Validate(ctx context.Context, cv *hasq.ChainValidate) (*hasq.ChainValidateReply, error) {
+	tokenId, err := parseId("token_id", cv.TokenId)
 	if err != nil {
 		return nil, err
 	}
-	result, err := s.db.Validate(tokenId)
+	result, err := s.db.Validate(ctx, tokenId)
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
 	}
 	return &hasq.ChainValidateReply{
 		Successful: result.Successful,
@@ -31,47 +59,60 @@ func (s *service) Validate(_ context.Context, cv *hasq.ChainValidate) (*hasq.Cha
 	}, nil
 }
 
-func (s *service) Owned(_ context.Context, own *hasq.OwnerCreate) (*hasq.OwnerCreateReply, error) {
-	tokenId, err := uuid.Parse(own.TokenId)
+func (s *service) Owned(ctx context.Context, own *hasq.OwnerCreate) (_ *hasq.OwnerCreateReply, err error) {
+	e := newAuditEvent(ctx, hasq.Service_Owned_FullMethodName, own.UserId, own.TokenId)
+	defer func() { s.auditFailure(ctx, e, err) }()
+	tokenId, err := parseId("token_id", own.TokenId)
 	if err != nil {
 		return nil, err
 	}
-	userId, err := uuid.Parse(own.UserId)
+	userId, err := parseId("user_id", own.UserId)
 	if err != nil {
 		return nil, err
 	}
-	err = s.db.Owner(userId, tokenId)
-	if err != nil {
+	if err = services.AuthorizeUser(ctx, userId.String()); err != nil {
 		return nil, err
 	}
+	err = s.db.Owner(ctx, userId, tokenId, e)
+	if err != nil {
+		return nil, statusError(err)
+	}
 	return &hasq.OwnerCreateReply{
 		Successful: true,
 	}, nil
 }
 
-func (s *service) CreateKey(_ context.Context, kc *hasq.KeyCreate) (*hasq.KeyCreateReply, error) {
-	tokenId, err := uuid.Parse(kc.TokenId)
+func (s *service) CreateKey(ctx context.Context, kc *hasq.KeyCreate) (_ *hasq.KeyCreateReply, err error) {
+	e := newAuditEvent(ctx, hasq.Service_CreateKey_FullMethodName, kc.UserId, kc.TokenId)
+	defer func() { s.auditFailure(ctx, e, err) }()
+	tokenId, err := parseId("token_id", kc.TokenId)
 	if err != nil {
 		return nil, err
 	}
-	userId, err := uuid.Parse(kc.UserId)
+	userId, err := parseId("user_id", kc.UserId)
 	if err != nil {
 		return nil, err
 	}
-	k, err := s.db.CreateKey(userId, tokenId, kc.Passphrase)
-	if err != nil {
+	// Ключ и владение пользователь получает только для себя
+	if err = services.AuthorizeUser(ctx, userId.String()); err != nil {
 		return nil, err
 	}
+	k, err := s.db.CreateKey(ctx, userId, tokenId, kc.Passphrase, e)
+	if err != nil {
+		return nil, statusError(err)
+	}
 	return &hasq.KeyCreateReply{
 		KeyId: k.Id.String(),
 		Hash:  k.Hash,
 	}, nil
 }
 
-func (s *service) CreateToken(_ context.Context, tc *hasq.TokenCreate) (*hasq.TokenReply, error) {
-	t, err := s.db.CreateToken(tc.Title, tc.Data)
+func (s *service) CreateToken(ctx context.Context, tc *hasq.TokenCreate) (_ *hasq.TokenReply, err error) {
+	e := newAuditEvent(ctx, hasq.Service_CreateToken_FullMethodName, "", "")
+	defer func() { s.auditFailure(ctx, e, err) }()
+	t, err := s.db.CreateToken(ctx, tc.Title, tc.Data, e)
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
 	}
 	return &hasq.TokenReply{
 		TokenId: t.Id.String(),
@@ -80,12 +121,12 @@ func (s *service) CreateToken(_ context.Context, tc *hasq.TokenCreate) (*hasq.To
 	}, nil
 }
 
-func (s *service) SearchToken(_ context.Context, ts *hasq.TokenSearch) (*hasq.TokenReply, error) {
+func (s *service) SearchToken(ctx context.Context, ts *hasq.TokenSearch) (*hasq.TokenReply, error) {
 	var id *uuid.UUID
 	var hash *string
 
 	if ts.GetTokenId() != "" {
-		uid, err := uuid.Parse(ts.GetTokenId())
+		uid, err := parseId("token_id", ts.GetTokenId())
 		if err != nil {
 			return nil, err
 		}
@@ -96,9 +137,9 @@ func (s *service) SearchToken(_ context.Context, ts *hasq.TokenSearch) (*hasq.To
 	} else {
 		return nil, status.Error(codes.NotFound, "Token not found")
 	}
-	t, err := s.db.SearchToken(id, hash)
+	t, err := s.db.SearchToken(ctx, id, hash)
 	if err != nil {
-		return nil, err
+		return nil, statusError(err)
 	}
 	return &hasq.TokenReply{
 		TokenId: t.Id.String(),
@@ -107,3 +148,74 @@ func (s *service) SearchToken(_ context.Context, ts *hasq.TokenSearch) (*hasq.To
 		Data:    t.Data,
 	}, nil
 }
+
+// AuditEvents выборка журнала аудита для клиентов с ролью аудитора
+func (s *service) AuditEvents(ctx context.Context, search *hasq.AuditSearch) (*hasq.AuditReply, error) {
+	if err := services.RequireRole(ctx, services.RoleAuditor); err != nil {
+		return nil, err
+	}
+	after, err := parseAuditPageToken(search.GetPageToken())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	limit := auditLimit(search.Limit)
+	filter := AuditFilter{Actor: search.Actor, Method: search.Method, After: after, Limit: limit + 1}
+	if search.TokenId != nil {
+		tokenId, err := parseId("token_id", *search.TokenId)
+		if err != nil {
+			return nil, err
+		}
+		filter.TokenId = &tokenId
+	}
+	if search.Since != nil {
+		since := search.Since.AsTime()
+		filter.Since = &since
+	}
+	if search.Until != nil {
+		until := search.Until.AsTime()
+		filter.Until = &until
+	}
+	events, err := s.db.AuditEvents(ctx, filter)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	reply := &hasq.AuditReply{}
+	if len(events) > limit {
+		events = events[:limit]
+		reply.NextPageToken = auditPageToken(events[limit-1].Id)
+	}
+	for _, e := range events {
+		event := &hasq.AuditEvent{
+			Id:       e.Id,
+			Time:     timestamppb.New(e.Time),
+			Actor:    e.Actor,
+			Peer:     e.Peer,
+			Method:   e.Method,
+			Outcome:  e.Outcome,
+			Error:    e.Error,
+			PrevHash: e.PrevHash,
+			Hash:     e.Hash,
+		}
+		if e.TokenId != nil {
+			event.TokenId = e.TokenId.String()
+		}
+		reply.Events = append(reply.Events, event)
+	}
+	return reply, nil
+}
+
+// VerifyAudit проверка цепочки хешей журнала аудита
+func (s *service) VerifyAudit(ctx context.Context, _ *hasq.AuditVerify) (*hasq.AuditVerifyReply, error) {
+	if err := services.RequireRole(ctx, services.RoleAuditor); err != nil {
+		return nil, err
+	}
+	check, err := s.db.VerifyAudit(ctx)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &hasq.AuditVerifyReply{
+		Successful: check.Successful,
+		Checked:    check.Checked,
+		BrokenId:   check.BrokenId,
+	}, nil
+}
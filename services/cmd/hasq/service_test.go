@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"pet/middleware/hasq"
+	"pet/services"
+)
+
+func TestService_Codes(t *testing.T) {
+	s := &service{db: NewMemoryDatabaseToken()}
+	ctx := context.Background()
+	token, err := s.CreateToken(ctx, &hasq.TokenCreate{Title: "Token", Data: []byte("DATA")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.CreateToken(ctx, &hasq.TokenCreate{Title: "Token", Data: []byte("DATA")})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("AlreadyExists expected, got %v", err)
+	}
+	_, err = s.Validate(ctx, &hasq.ChainValidate{TokenId: "not-a-uuid"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("InvalidArgument expected, got %v", err)
+	}
+	_, err = s.Validate(ctx, &hasq.ChainValidate{TokenId: uuid.NewString()})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("NotFound expected, got %v", err)
+	}
+	_, err = s.Owned(ctx, &hasq.OwnerCreate{UserId: uuid.NewString(), TokenId: token.TokenId})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("NotFound expected for a user without keys, got %v", err)
+	}
+}
+
+func TestService_Subject(t *testing.T) {
+	s := &service{db: NewMemoryDatabaseToken()}
+	token, err := s.CreateToken(context.Background(), &hasq.TokenCreate{Title: "Token", Data: []byte("DATA")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, other := uuid.NewString(), uuid.NewString()
+	ctx := services.WithIdentity(context.Background(), &services.Identity{Subject: user})
+
+	_, err = s.CreateKey(ctx, &hasq.KeyCreate{UserId: other, TokenId: token.TokenId, Passphrase: "secret"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("PermissionDenied expected for a key of another user, got %v", err)
+	}
+	if _, err = s.CreateKey(ctx, &hasq.KeyCreate{UserId: user, TokenId: token.TokenId, Passphrase: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Owned(ctx, &hasq.OwnerCreate{UserId: other, TokenId: token.TokenId})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("PermissionDenied expected for ownership of another user, got %v", err)
+	}
+	if _, err = s.Owned(ctx, &hasq.OwnerCreate{UserId: user, TokenId: token.TokenId}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestService_Audit(t *testing.T) {
+	db := NewMemoryDatabaseToken()
+	s := &service{db: db}
+	user := uuid.NewString()
+	ctx := services.WithIdentity(context.Background(), &services.Identity{Subject: user})
+	token, err := s.CreateToken(ctx, &hasq.TokenCreate{Title: "Token", Data: []byte("DATA")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.CreateKey(ctx, &hasq.KeyCreate{UserId: user, TokenId: token.TokenId, Passphrase: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	// Отклоненный вызов не записывается в журнал, а вызов без ключа записывается
+	_, _ = s.Owned(ctx, &hasq.OwnerCreate{UserId: uuid.NewString(), TokenId: token.TokenId})
+	other := uuid.NewString()
+	_, _ = s.Owned(services.WithIdentity(context.Background(), &services.Identity{Subject: other}),
+		&hasq.OwnerCreate{UserId: other, TokenId: token.TokenId})
+	// Чтение не записывается в журнал
+	_, _ = s.Validate(ctx, &hasq.ChainValidate{TokenId: token.TokenId})
+
+	if _, err = s.AuditEvents(ctx, &hasq.AuditSearch{}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("PermissionDenied expected without the auditor role, got %v", err)
+	}
+	auditor := services.WithIdentity(context.Background(), &services.Identity{Subject: "a1", Roles: []string{services.RoleAuditor}})
+	limit := uint32(2)
+	page, err := s.AuditEvents(auditor, &hasq.AuditSearch{TokenId: &token.TokenId, Limit: &limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 2 || page.NextPageToken == "" {
+		t.Fatalf("first page of 2 events expected, got %v", page)
+	}
+	if e := page.Events[0]; e.Method != hasq.Service_CreateToken_FullMethodName || e.Actor != user || e.Outcome != "OK" {
+		t.Fatalf("CreateToken event expected, got %v", e)
+	}
+	page, err = s.AuditEvents(auditor, &hasq.AuditSearch{TokenId: &token.TokenId, Limit: &limit, PageToken: &page.NextPageToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || page.NextPageToken != "" {
+		t.Fatalf("last page of 1 event expected, got %v", page)
+	}
+	if e := page.Events[0]; e.Method != hasq.Service_Owned_FullMethodName || e.Actor != other || e.Outcome != codes.NotFound.String() || e.Error == "" {
+		t.Fatalf("failed Owned event expected, got %v", e)
+	}
+	bad := "bad"
+	if _, err = s.AuditEvents(auditor, &hasq.AuditSearch{PageToken: &bad}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("InvalidArgument expected, got %v", err)
+	}
+
+	check, err := s.VerifyAudit(auditor, &hasq.AuditVerify{})
+	if err != nil || !check.Successful || check.Checked != 3 {
+		t.Fatalf("valid log of 3 events expected, got %v, %v", check, err)
+	}
+	db.(*memoryDatabase).audit[1].Actor = "someone else"
+	check, err = s.VerifyAudit(auditor, &hasq.AuditVerify{})
+	if err != nil || check.Successful || check.GetBrokenId() != 2 || check.Checked != 1 {
+		t.Fatalf("tampered event 2 expected, got %v, %v", check, err)
+	}
+}
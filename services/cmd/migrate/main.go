@@ -0,0 +1,166 @@
+// Команда migrate управляет миграциями схем баз данных сервисов.
+//
+//	migrate [-service class] [-database-url URL] <command> [arg]
+//
+// Команды:
+//
+//	up [N]          применить все или N следующих миграций
+//	down [N|all]    откатить N миграций, по умолчанию одну, или все
+//	goto V          перейти к версии V
+//	version         вывести текущую версию
+//	force V         записать версию V без выполнения миграций, -1 без версии
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+
+	"pet/services"
+	"pet/services/migrations"
+)
+
+func main() {
+	service := flag.String("service", "class", "Service which schema is migrated: class, hasq")
+	url := flag.String("database-url", os.Getenv("DATABASE_URL"), "Postgres connection URL (DATABASE_URL)")
+	timeout := flag.Duration("timeout", 30*time.Second, "Time to wait for the database")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] up [N] | down [N|all] | goto V | version | force V\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	source, err := migrations.For(*service)
+	if err != nil {
+		slog.Error("Unknown service", slog.String("err", err.Error()))
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg := services.DefaultConfig(*service, 0)
+	cfg.Database.Url = *url
+	cfg.Database.ConnectTimeout = *timeout
+	db, err := services.OpenDatabase(ctx, &cfg)
+	if err != nil {
+		slog.Error("Can't open database", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	defer func() { _ = db.Close() }()
+	m, err := services.NewMigrate(ctx, db, *service, source)
+	if err != nil {
+		slog.Error("Can't create migration", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	defer func() { _, _ = m.Close() }()
+	m.Log = logger{}
+	go func() {
+		<-ctx.Done()
+		m.GracefulStop <- true
+	}()
+
+	if err = run(m, flag.Args(), os.Stdout); err != nil {
+		slog.Error("Migration failed", slog.String("service", *service), slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+}
+
+// run выполняет команду args[0] с аргументом args[1] и выводит версию схемы
+func run(m *migrate.Migrate, args []string, out io.Writer) error {
+	command, arg := args[0], ""
+	if len(args) > 1 {
+		arg = args[1]
+	}
+	var err error
+	switch command {
+	case "up":
+		if arg == "" {
+			err = m.Up()
+			break
+		}
+		var n int
+		if n, err = positive(arg); err == nil {
+			err = m.Steps(n)
+		}
+	case "down":
+		if arg == "all" {
+			err = m.Down()
+			break
+		}
+		n := 1
+		if arg != "" {
+			n, err = positive(arg)
+		}
+		if err == nil {
+			err = m.Steps(-n)
+		}
+	case "goto":
+		var version uint64
+		if version, err = strconv.ParseUint(arg, 10, 64); err == nil {
+			err = m.Migrate(uint(version))
+		}
+	case "force":
+		var version int
+		if version, err = strconv.Atoi(arg); err == nil {
+			err = m.Force(version)
+		}
+	case "version":
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+	if errors.Is(err, migrate.ErrNoChange) {
+		_, _ = fmt.Fprintln(out, "no change")
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		_, _ = fmt.Fprintln(out, "no version")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dirty {
+		_, _ = fmt.Fprintf(out, "%d (dirty)\n", version)
+		return nil
+	}
+	_, _ = fmt.Fprintln(out, version)
+	return nil
+}
+
+func positive(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n <= 0 {
+		err = fmt.Errorf("number of migrations must be positive, got %d", n)
+	}
+	return n, err
+}
+
+// logger выводит ход миграции в журнал
+type logger struct{}
+
+func (logger) Printf(format string, v ...any) {
+	slog.Info(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (logger) Verbose() bool {
+	return true
+}
@@ -0,0 +1,158 @@
+// Команда outbox публикует события из таблицы outbox сервиса заново и
+// перематывает группы потребителей потока событий.
+//
+//	outbox [-service hasq] [-database-url URL] [-redis-url URL] [-stream S] <command> [flags]
+//
+// Команды:
+//
+//	replay [-from ID] [-to ID] [-type T]  опубликовать события с Id от from до to
+//	rewind -group G [-id ID]              читать поток группой G после сообщения ID, по умолчанию с начала
+//
+// Повторно опубликованные события сохраняют свои Id, потребители отличают
+// по ним повторы.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"pet/services"
+)
+
+// replayBatch число событий, читаемых из outbox за один запрос
+const replayBatch = 500
+
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("Outbox command failed", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("outbox", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	service := fs.String("service", "hasq", "Service which events are replayed: class, hasq")
+	databaseUrl := fs.String("database-url", os.Getenv("DATABASE_URL"), "Postgres connection URL (DATABASE_URL)")
+	redisUrl := fs.String("redis-url", os.Getenv("REDIS_URL"), "Redis connection URL (REDIS_URL)")
+	stream := fs.String("stream", "", "Redis stream, <service>.events by default")
+	timeout := fs.Duration("timeout", 30*time.Second, "Time to wait for the database")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: outbox [flags] replay [-from ID] [-to ID] [-type T] | rewind -group G [-id ID]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	if *service != "class" && *service != "hasq" {
+		_, _ = fmt.Fprintf(stderr, "Unknown service %q\n", *service)
+		return errUsage
+	}
+	cfg := services.DefaultConfig(*service, 0)
+	if *databaseUrl != "" {
+		cfg.Database.Url = *databaseUrl
+	}
+	if *redisUrl != "" {
+		cfg.Redis.Url = *redisUrl
+	}
+	if *stream != "" {
+		cfg.Outbox.Stream = *stream
+	}
+	cfg.Database.ConnectTimeout = *timeout
+	client, err := services.RedisClient(&cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	command, rest := fs.Arg(0), fs.Args()[1:]
+	switch command {
+	case "replay":
+		return replay(ctx, &cfg, client, rest, stdout, stderr)
+	case "rewind":
+		return rewind(ctx, &cfg, client, rest, stdout, stderr)
+	}
+	fs.Usage()
+	return errUsage
+}
+
+// replay публикует события outbox заново, не меняя отметки о публикации
+func replay(ctx context.Context, cfg *services.Config, client *redis.Client, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("outbox replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	from := fs.Int64("from", 1, "First event id")
+	to := fs.Int64("to", 0, "Last event id, 0 for the latest")
+	eventType := fs.String("type", "", "Replay only events of this type: "+
+		services.EventTokenCreated+", "+services.EventOwnershipTransferred+", "+services.EventElementPublished)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	db, err := services.OpenDatabase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+	replayed := 0
+	for next := *from; ; {
+		events, err := services.OutboxEvents(ctx, db, next, *to, *eventType, replayBatch)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			e.Service = cfg.Service
+			if err = services.PublishEvent(ctx, client, cfg.Outbox.Stream, cfg.Outbox.MaxLen, e); err != nil {
+				return fmt.Errorf("event %d: %w", e.Id, err)
+			}
+			replayed++
+			next = e.Id + 1
+		}
+		if len(events) < replayBatch {
+			break
+		}
+	}
+	_, _ = fmt.Fprintf(stdout, "%d events replayed to %s\n", replayed, cfg.Outbox.Stream)
+	return nil
+}
+
+// rewind переставляет группу потребителей, чтобы она прочитала поток заново
+func rewind(ctx context.Context, cfg *services.Config, client *redis.Client, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("outbox rewind", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	group := fs.String("group", "", "Consumer group")
+	id := fs.String("id", "0", "Stream message id after which the group reads, 0 for the stream start")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	if *group == "" {
+		_, _ = fmt.Fprintln(stderr, "Flag -group is required")
+		fs.Usage()
+		return errUsage
+	}
+	if err := client.XGroupSetID(ctx, cfg.Outbox.Stream, *group, *id).Err(); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "group %s of %s reads after %s\n", *group, cfg.Outbox.Stream, *id)
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pet/middleware/class"
+)
+
+func (c *command) class(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return c.classList(args[1:])
+		case "elements":
+			return c.classElements(args[1:])
+		}
+	}
+	return c.unknown("class", args, "list", "elements")
+}
+
+// enumValue значение перечисления по имени без учета регистра
+func enumValue(values map[string]int32, name string) (int32, error) {
+	v, ok := values[strings.ToUpper(name)]
+	if !ok || v == 0 {
+		return 0, fmt.Errorf("unknown status %q", name)
+	}
+	return v, nil
+}
+
+// versionFlag разбирает номер версии в поле target
+func versionFlag(target **uint32) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return err
+		}
+		version := uint32(n)
+		*target = &version
+		return nil
+	}
+}
+
+func (c *command) classList(args []string) error {
+	req := &class.ClassRequest{}
+	fs := c.flags("class list", "[flags]")
+	fs.Func("name", "Classes which name contains the value", func(v string) error {
+		req.NameFilter = &v
+		return nil
+	})
+	fs.Func("status", "Class status: CLASS_DRAFT, CLASS_PUBLISHED, CLASS_ARCHIVED", func(v string) error {
+		s, err := enumValue(class.ClassStatus_value, v)
+		status := class.ClassStatus(s)
+		req.Status = &status
+		return err
+	})
+	fs.Func("version", "Classes of the current version", versionFlag(&req.Version))
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	reply, err := c.client.Class().Classes(c.ctx, req)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(reply.Classes))
+	for _, cl := range reply.Classes {
+		rows = append(rows, []string{cl.Name, cl.Title, cl.Status.String(),
+			strconv.FormatUint(uint64(cl.Version), 10), optional(cl.Parent)})
+	}
+	return c.out.write(reply, []string{"NAME", "TITLE", "STATUS", "VERSION", "PARENT"}, rows)
+}
+
+func (c *command) classElements(args []string) error {
+	req := &class.ClassElementRequest{}
+	fs := c.flags("class elements", "NAME [flags]")
+	fs.Func("status", "Element status: ITEM_DRAFT, ITEM_PUBLISHED, ITEM_SKIP", func(v string) error {
+		s, err := enumValue(class.ClassElementStatus_value, v)
+		status := class.ClassElementStatus(s)
+		req.Status = &status
+		return err
+	})
+	fs.Func("version", "Elements of the version", versionFlag(&req.Version))
+	fs.Func("subtree", "Only the element with the key and its descendants", func(v string) error {
+		req.Subtree = &v
+		return nil
+	})
+	positional, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	req.Name = positional[0]
+	elements, err := c.client.AllElements(c.ctx, req)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(elements))
+	for _, e := range elements {
+		rows = append(rows, []string{e.Key, e.Value, strconv.FormatUint(uint64(e.Version), 10),
+			e.Status.String(), optional(e.ParentKey)})
+	}
+	reply := &class.ClassElementReply{Name: req.Name, Elements: elements, Eof: true}
+	return c.out.write(reply, []string{"KEY", "VALUE", "VERSION", "STATUS", "PARENT"}, rows)
+}
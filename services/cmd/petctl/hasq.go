@@ -0,0 +1,265 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"pet/middleware/hasq"
+)
+
+func (c *command) hasq(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "token":
+			if len(args) > 1 && args[1] == "create" {
+				return c.tokenCreate(args[2:])
+			} else if len(args) > 1 && args[1] == "get" {
+				return c.tokenGet(args[2:])
+			}
+			return c.unknown("hasq token", args[1:], "create", "get")
+		case "key":
+			if len(args) > 1 && args[1] == "create" {
+				return c.keyCreate(args[2:])
+			}
+			return c.unknown("hasq key", args[1:], "create")
+		case "own":
+			return c.own(args[1:])
+		case "validate":
+			return c.validate(args[1:])
+		case "audit":
+			if len(args) > 1 && args[1] == "verify" {
+				return c.auditVerify(args[2:])
+			}
+			return c.audit(args[1:])
+		}
+	}
+	return c.unknown("hasq", args, "token", "key", "own", "validate", "audit")
+}
+
+// required проверяет, что заданы флаги из пар имя, значение
+func required(fs *flag.FlagSet, pairs ...string) error {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			_, _ = fmt.Fprintf(fs.Output(), "Flag -%s is required\n", pairs[i])
+			fs.Usage()
+			return errUsage
+		}
+	}
+	return nil
+}
+
+// passphraseFlag фраза ключа, по умолчанию из HASQ_PASSPHRASE, чтобы она
+// не оставалась в истории команд
+func passphraseFlag(fs *flag.FlagSet) *string {
+	return fs.String("passphrase", os.Getenv("HASQ_PASSPHRASE"), "Key passphrase (HASQ_PASSPHRASE)")
+}
+
+func (c *command) writeToken(t *hasq.TokenReply) error {
+	return c.out.write(t, []string{"TOKEN_ID", "TITLE", "HASH", "DATA"},
+		[][]string{{t.TokenId, t.Title, t.Hash, strconv.Itoa(len(t.Data)) + " bytes"}})
+}
+
+func (c *command) tokenCreate(args []string) error {
+	fs := c.flags("hasq token create", "-title T (-file F | -data D)")
+	title := fs.String("title", "", "Token title")
+	file := fs.String("file", "", "File with token data, - for stdin")
+	data := fs.String("data", "", "Token data")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := required(fs, "title", *title); err != nil {
+		return err
+	}
+	if (*file == "") == (*data == "") {
+		fs.Usage()
+		return errUsage
+	}
+	content := []byte(*data)
+	if *file != "" {
+		var err error
+		if content, err = readFile(*file); err != nil {
+			return err
+		}
+	}
+	t, err := c.client.Hasq().CreateToken(c.ctx, &hasq.TokenCreate{Title: *title, Data: content})
+	if err != nil {
+		return err
+	}
+	t.Data = content
+	return c.writeToken(t)
+}
+
+// readFile содержимое файла name или стандартного ввода для "-"
+func readFile(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
+
+func (c *command) tokenGet(args []string) error {
+	fs := c.flags("hasq token get", "ID | -hash H")
+	hash := fs.String("hash", "", "Token hash")
+	positional, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	search := &hasq.TokenSearch{}
+	switch {
+	case len(positional) == 1 && *hash == "":
+		search.Search = &hasq.TokenSearch_TokenId{TokenId: positional[0]}
+	case len(positional) == 0 && *hash != "":
+		search.Search = &hasq.TokenSearch_TokenHash{TokenHash: *hash}
+	default:
+		fs.Usage()
+		return errUsage
+	}
+	t, err := c.client.Hasq().SearchToken(c.ctx, search)
+	if err != nil {
+		return err
+	}
+	return c.writeToken(t)
+}
+
+func (c *command) keyCreate(args []string) error {
+	fs := c.flags("hasq key create", "-user U -token T -passphrase P")
+	user := fs.String("user", "", "User id")
+	token := fs.String("token", "", "Token id")
+	passphrase := passphraseFlag(fs)
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := required(fs, "user", *user, "token", *token, "passphrase", *passphrase); err != nil {
+		return err
+	}
+	k, err := c.client.Hasq().CreateKey(c.ctx, &hasq.KeyCreate{UserId: *user, TokenId: *token, Passphrase: *passphrase})
+	if err != nil {
+		return err
+	}
+	return c.out.write(k, []string{"KEY_ID", "HASH"}, [][]string{{k.KeyId, k.Hash}})
+}
+
+// own закрепляет владение последним ключом пользователя, а с фразой
+// сначала создает ключ и проверяет цепочку после передачи
+func (c *command) own(args []string) error {
+	fs := c.flags("hasq own", "-user U -token T [-passphrase P]")
+	user := fs.String("user", "", "User id")
+	token := fs.String("token", "", "Token id")
+	passphrase := passphraseFlag(fs)
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := required(fs, "user", *user, "token", *token); err != nil {
+		return err
+	}
+	if *passphrase == "" {
+		reply, err := c.client.Hasq().Owned(c.ctx, &hasq.OwnerCreate{UserId: *user, TokenId: *token})
+		if err != nil {
+			return err
+		}
+		return c.out.write(reply, []string{"SUCCESSFUL"}, [][]string{{strconv.FormatBool(reply.Successful)}})
+	}
+	o, err := c.client.TakeOwnership(c.ctx, *user, *token, *passphrase)
+	if err != nil {
+		return err
+	}
+	return c.out.write(o, []string{"KEY_ID", "KEY_HASH", "LAST_NUM"},
+		[][]string{{o.KeyId, o.KeyHash, strconv.FormatUint(o.LastNum, 10)}})
+}
+
+func (c *command) validate(args []string) error {
+	fs := c.flags("hasq validate", "TOKEN")
+	positional, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	reply, err := c.client.Hasq().Validate(c.ctx, &hasq.ChainValidate{TokenId: positional[0]})
+	if err != nil {
+		return err
+	}
+	return c.out.write(reply, []string{"SUCCESSFUL", "OWNER_ID", "LAST_NUM"},
+		[][]string{{strconv.FormatBool(reply.Successful), reply.OwnerId, strconv.FormatUint(reply.LastNum, 10)}})
+}
+
+// audit выводит страницу журнала аудита, следующая запрашивается с -page
+func (c *command) audit(args []string) error {
+	fs := c.flags("hasq audit", "[-actor A] [-token T] [-method M] [-since TIME] [-until TIME] [-limit N] [-page P]")
+	actor := fs.String("actor", "", "Actor user id")
+	token := fs.String("token", "", "Token id")
+	method := fs.String("method", "", "Full method name, /hasq.Service/Owned")
+	since := fs.String("since", "", "Events from this RFC 3339 time")
+	until := fs.String("until", "", "Events before this RFC 3339 time")
+	limit := fs.Uint("limit", 0, "Page size")
+	page := fs.String("page", "", "Page token from the previous page")
+	positional, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return errUsage
+	}
+	search := &hasq.AuditSearch{}
+	for _, f := range []struct {
+		value  string
+		target **string
+	}{{*actor, &search.Actor}, {*token, &search.TokenId}, {*method, &search.Method}, {*page, &search.PageToken}} {
+		if f.value != "" {
+			*f.target = &f.value
+		}
+	}
+	for _, f := range []struct {
+		name, value string
+		target      **timestamppb.Timestamp
+	}{{"since", *since, &search.Since}, {"until", *until, &search.Until}} {
+		if f.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, f.value)
+		if err != nil {
+			_, _ = fmt.Fprintf(fs.Output(), "Invalid -%s: %v\n", f.name, err)
+			return errUsage
+		}
+		*f.target = timestamppb.New(t)
+	}
+	if *limit > 0 {
+		size := uint32(*limit)
+		search.Limit = &size
+	}
+	reply, err := c.client.Hasq().AuditEvents(c.ctx, search)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(reply.Events))
+	for _, e := range reply.Events {
+		rows = append(rows, []string{strconv.FormatUint(e.Id, 10), e.Time.AsTime().Format(time.RFC3339),
+			e.Actor, e.Peer, e.Method, e.TokenId, e.Outcome, e.Error})
+	}
+	if reply.NextPageToken != "" {
+		_, _ = fmt.Fprintf(c.stderr, "Next page: -page %s\n", reply.NextPageToken)
+	}
+	return c.out.write(reply, []string{"ID", "TIME", "ACTOR", "PEER", "METHOD", "TOKEN_ID", "OUTCOME", "ERROR"}, rows)
+}
+
+func (c *command) auditVerify(args []string) error {
+	fs := c.flags("hasq audit verify", "")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	reply, err := c.client.Hasq().VerifyAudit(c.ctx, &hasq.AuditVerify{})
+	if err != nil {
+		return err
+	}
+	return c.out.write(reply, []string{"SUCCESSFUL", "CHECKED", "BROKEN_ID"},
+		[][]string{{strconv.FormatBool(reply.Successful), strconv.FormatUint(reply.Checked, 10), optional(reply.BrokenId)}})
+}
@@ -0,0 +1,166 @@
+// Команда petctl вызывает сервисы class и hasq по gRPC.
+//
+//	petctl [-class-address A] [-hasq-address A] [-jwt JWT] [-output table|json] <service> <command> [args]
+//
+// Команды:
+//
+//	class list [-name F] [-status S] [-version V]
+//	class elements NAME [-status S] [-version V] [-subtree KEY]
+//	hasq token create -title T (-file F | -data D)
+//	hasq token get ID | -hash H
+//	hasq key create -user U -token T -passphrase P
+//	hasq own -user U -token T [-passphrase P]
+//	hasq validate TOKEN
+//	hasq audit [-actor A] [-token T] [-method M] [-since TIME] [-until TIME] [-limit N] [-page P]
+//	hasq audit verify
+//
+// Адреса по умолчанию берутся из CLASS_ADDRESS и HASQ_ADDRESS, JWT из PET_JWT.
+// С -tls-ca или -tls-cert подключение устанавливается по TLS.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"pet/client"
+	"pet/services"
+)
+
+// errUsage неверные аргументы команды, описание уже выведено
+var errUsage = errors.New("usage")
+
+// env значение переменной name или def, если она не задана
+func env(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	} else if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "petctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run разбирает общие флаги и выполняет команду args
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("petctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cfg := client.DefaultConfig()
+	fs.StringVar(&cfg.ClassAddress, "class-address", env("CLASS_ADDRESS", client.DefaultClassAddress),
+		"Class service address (CLASS_ADDRESS)")
+	fs.StringVar(&cfg.HasqAddress, "hasq-address", env("HASQ_ADDRESS", client.DefaultHasqAddress),
+		"Hasq service address (HASQ_ADDRESS)")
+	fs.StringVar(&cfg.Token, "jwt", os.Getenv("PET_JWT"), "JWT of the caller (PET_JWT)")
+	fs.DurationVar(&cfg.Timeout, "timeout", 10*time.Second, "Deadline of each call")
+	var tlsCfg services.ClientTlsConfig
+	fs.StringVar(&tlsCfg.CAFile, "tls-ca", os.Getenv("PET_TLS_CA"), "CA certificates of the services, enables TLS (PET_TLS_CA)")
+	fs.StringVar(&tlsCfg.CertFile, "tls-cert", os.Getenv("PET_TLS_CERT"), "Client certificate for mTLS (PET_TLS_CERT)")
+	fs.StringVar(&tlsCfg.KeyFile, "tls-key", os.Getenv("PET_TLS_KEY"), "Client private key for mTLS (PET_TLS_KEY)")
+	fs.StringVar(&tlsCfg.ServerName, "tls-server-name", "", "Name in the service certificates")
+	format := fs.String("output", env("PETCTL_OUTPUT", "table"), "Output format: table, json (PETCTL_OUTPUT)")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: petctl [flags] class list|elements | hasq token|key|own|validate|audit\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	out, err := newOutput(*format, stdout)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return errUsage
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errUsage
+	}
+	if tlsCfg.Enabled() {
+		transport, err := services.ClientTransport(tlsCfg)
+		if err != nil {
+			return err
+		}
+		cfg.DialOptions = append(cfg.DialOptions, transport)
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	cmd := &command{ctx: ctx, client: c, out: out, stderr: stderr}
+	service, rest := fs.Arg(0), fs.Args()[1:]
+	switch service {
+	case "class":
+		return cmd.class(rest)
+	case "hasq":
+		return cmd.hasq(rest)
+	}
+	fs.Usage()
+	return errUsage
+}
+
+// command общие для команд подключение и вывод
+type command struct {
+	ctx    context.Context
+	client *client.Client
+	out    output
+	stderr io.Writer
+}
+
+// flags набор флагов подкоманды name
+func (c *command) flags(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet("petctl "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(c.stderr, "Usage: petctl %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse разбирает args, в которых флаги могут идти после позиционных
+// аргументов, как в "class elements sex -status ITEM_PUBLISHED". После "--"
+// все аргументы позиционные.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		if parsed := len(args) - fs.NArg(); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, fs.Args()...), nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// unknown сообщает о неизвестной подкоманде
+func (c *command) unknown(service string, args []string, commands ...string) error {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+	_, _ = fmt.Fprintf(c.stderr, "Unknown %s command %q, expected one of: %s\n", service, name,
+		strings.Join(commands, ", "))
+	return errUsage
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"pet/middleware/class"
+)
+
+type fakeClass struct {
+	class.UnimplementedServiceServer
+	last *class.ClassElementRequest
+}
+
+func (s *fakeClass) Elements(_ context.Context, req *class.ClassElementRequest) (*class.ClassElementReply, error) {
+	s.last = req
+	parent := "m"
+	return &class.ClassElementReply{Name: req.Name, Eof: true, Elements: []*class.ClassElement{
+		{Key: "m", Value: "мужской", Version: 1, Status: class.ClassElementStatus_ITEM_PUBLISHED},
+		{Key: "x", Value: "child", Version: 1, Status: class.ClassElementStatus_ITEM_PUBLISHED, ParentKey: &parent},
+	}}, nil
+}
+
+func startClass(t *testing.T) (*fakeClass, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	s := &fakeClass{}
+	class.RegisterServiceServer(server, s)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	return s, listener.Addr().String()
+}
+
+func TestRun_ClassElements(t *testing.T) {
+	s, address := startClass(t)
+	var out, errs bytes.Buffer
+	err := run(context.Background(), []string{"-class-address", address,
+		"class", "elements", "sex", "-status", "item_published", "-version", "1"}, &out, &errs)
+	if err != nil {
+		t.Fatal(err, errs.String())
+	}
+	if s.last.Name != "sex" || s.last.GetStatus() != class.ClassElementStatus_ITEM_PUBLISHED || s.last.GetVersion() != 1 {
+		t.Fatalf("unexpected request %v", s.last)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "KEY") || !strings.HasSuffix(lines[2], "m") {
+		t.Fatalf("unexpected table\n%s", out.String())
+	}
+
+	out.Reset()
+	err = run(context.Background(), []string{"-class-address", address, "-output", "json",
+		"class", "elements", "sex"}, &out, &errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Name     string `json:"name"`
+		Elements []struct {
+			Key       string `json:"key"`
+			Status    string `json:"status"`
+			ParentKey string `json:"parent_key"`
+		} `json:"elements"`
+	}
+	if err = json.Unmarshal(out.Bytes(), &reply); err != nil {
+		t.Fatal(err, out.String())
+	}
+	if reply.Name != "sex" || len(reply.Elements) != 2 || reply.Elements[1].ParentKey != "m" ||
+		reply.Elements[0].Status != "ITEM_PUBLISHED" {
+		t.Fatalf("unexpected json %s", out.String())
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		args       []string
+		positional string
+		status     string
+	}{
+		{[]string{"sex", "-status", "s"}, "sex", "s"},
+		{[]string{"-status", "s", "sex", "m"}, "sex m", "s"},
+		{[]string{"sex", "--", "-status", "s"}, "sex -status s", ""},
+		{[]string{"--", "--", "sex"}, "-- sex", ""},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		status := fs.String("status", "", "")
+		positional, err := parse(fs, c.args)
+		if err != nil || strings.Join(positional, " ") != c.positional || *status != c.status {
+			t.Fatalf("%q: %q and status %q expected, got %q, %q, %v", c.args, c.positional, c.status, positional, *status, err)
+		}
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"class"},
+		{"class", "drop"},
+		{"-output", "xml", "class", "list"},
+		{"class", "elements"},
+		{"class", "elements", "sex", "-status", "unknown"},
+		{"hasq", "token", "create", "-title", "t"},
+		{"hasq", "key", "create", "-user", "u"},
+		{"hasq", "validate"},
+		{"hasq", "audit", "-since", "yesterday"},
+	} {
+		var out, errs bytes.Buffer
+		if err := run(context.Background(), args, &out, &errs); !errors.Is(err, errUsage) {
+			t.Fatalf("%q: usage error expected, got %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// output выводит результат команды: value целиком в JSON или его строки
+// rows с заголовками columns таблицей
+type output interface {
+	write(value any, columns []string, rows [][]string) error
+}
+
+func newOutput(format string, w io.Writer) (output, error) {
+	switch format {
+	case "table":
+		return tableOutput{w}, nil
+	case "json":
+		return jsonOutput{w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected table or json", format)
+}
+
+type tableOutput struct {
+	w io.Writer
+}
+
+func (o tableOutput) write(_ any, columns []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+type jsonOutput struct {
+	w io.Writer
+}
+
+func (o jsonOutput) write(value any, _ []string, _ [][]string) error {
+	var data []byte
+	var err error
+	if m, ok := value.(proto.Message); ok {
+		// Имена полей как в .proto и в файлах requests/*.http
+		data, err = protojson.MarshalOptions{Multiline: true, Indent: "  ", UseProtoNames: true}.Marshal(m)
+	} else {
+		data, err = json.MarshalIndent(value, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(o.w, string(data))
+	return err
+}
+
+// optional значение указателя или пустая строка
+func optional[T any](v *T) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(*v)
+}
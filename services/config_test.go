@@ -0,0 +1,153 @@
+package services
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func environment(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := values[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), nil,
+		environment(nil), DefaultConfig("class", 51051))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 51051 || cfg.Metrics.Port != 8081 || cfg.Log.Level != slog.LevelInfo || !cfg.Log.Color {
+		t.Fatalf("unexpected defaults %v", cfg)
+	}
+	if cfg.Database.InMemory() {
+		t.Fatal("postgres expected by default")
+	}
+}
+
+func TestLoadConfig_MemoryDatabase(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-database-url", "memory://"},
+		environment(nil), DefaultConfig("class", 51051))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Database.InMemory() {
+		t.Fatal("in-memory database expected")
+	}
+}
+
+func TestLoadConfig_Order(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	file := writeFile(t, filepath.Join(dir, "service.conf"),
+		"PORT=1001\nMETRICS_PORT=2001\nLOG_LEVEL=WARN\nCACHE_TTL=1m\nLOG_FILE=file.log\n")
+	writeFile(t, ".env", "METRICS_PORT=2002\nLOG_LEVEL=ERROR\nCACHE_TTL=2m\n")
+	writeFile(t, ".env.local", "LOG_LEVEL=DEBUG\nCACHE_TTL=3m\n")
+	env := environment(map[string]string{"CONFIG_FILE": file, "CACHE_TTL": "4m", "LOG_COLOR": "false"})
+
+	cfg, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-cache-poll", "7s"}, env, DefaultConfig("class", 51051))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 1001 {
+		t.Fatalf("port from file expected, got %d", cfg.Port)
+	}
+	if cfg.Metrics.Port != 2002 {
+		t.Fatalf("metrics port from .env expected, got %d", cfg.Metrics.Port)
+	}
+	if cfg.Log.Level != slog.LevelDebug {
+		t.Fatalf("log level from .env.local expected, got %s", cfg.Log.Level)
+	}
+	if cfg.Cache.Ttl != 4*time.Minute {
+		t.Fatalf("cache ttl from environment expected, got %s", cfg.Cache.Ttl)
+	}
+	if cfg.Cache.Poll != 7*time.Second {
+		t.Fatalf("cache poll from flag expected, got %s", cfg.Cache.Poll)
+	}
+	if cfg.Log.File != "file.log" || cfg.Log.Color {
+		t.Fatalf("unexpected log settings %v", cfg.Log)
+	}
+
+	cfg, err = loadConfig(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-config", file, "-port", "1005", "-cache-ttl", "5m"}, env, DefaultConfig("class", 51051))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 1005 || cfg.Cache.Ttl != 5*time.Minute {
+		t.Fatalf("flags must override everything, got %v", cfg)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cases := []map[string]string{
+		{"PORT": "port"},
+		{"PORT": "70000"},
+		{"METRICS_PORT": "51051"},
+		{"LOG_LEVEL": "LOUD"},
+		{"DATABASE_URL": "mysql://localhost/pet"},
+		{"DATABASE_MAX_IDLE": "30"},
+		{"DATABASE_CONNECT_TIMEOUT": "0s"},
+		{"REDIS_URL": "http://localhost"},
+		{"CACHE_POLL": "0s"},
+		{"CACHE_BACKEND": "memcached"},
+		{"GRPC_DEADLINE": "-1s"},
+		{"TRACING_EXPORTER": "jaeger"},
+		{"LOG_FORMAT": "xml"},
+		{"LOG_BACKUPS": "-1"},
+		{"TRACING_SAMPLE_RATIO": "2"},
+		{"AUTH_MODE": "basic"},
+		{"AUTH_MODE": "jwt"},
+		{"AUTH_ROLES": "Lookup=reader"},
+		{"AUTH_MODE": "mtls"},
+		{"TLS_CERT_FILE": "server.pem"},
+		{"TLS_CLIENT_AUTH": "require"},
+		{"TLS_CLIENT_AUTH": "maybe"},
+		{"RATE_LIMIT": "Owned=5"},
+		{"RATE_LIMIT": "*=0"},
+		{"RATE_LIMIT": "*=5:0.5"},
+		{"RATE_LIMIT_BACKEND": "etcd"},
+		{"OUTBOX_INTERVAL": "0s"},
+		{"OUTBOX_BATCH": "0"},
+		{"OUTBOX_STREAM_MAXLEN": "-1"},
+		{"CONFIG_FILE": "missing.conf"},
+	}
+	for _, values := range cases {
+		_, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), nil,
+			environment(values), DefaultConfig("class", 51051))
+		if err == nil {
+			t.Fatalf("%v: expected error", values)
+		}
+	}
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	cfg := DefaultConfig("class", 51051)
+	cfg.Database.Url = "postgres://postgres:secret@db:5432/pet?sslmode=disable"
+	cfg.Redis.Url = "redis://localhost:6379/10?password=hidden"
+	cfg.Auth.JwtSecret = "covert"
+	text := cfg.String()
+	if strings.Contains(text, "secret") || strings.Contains(text, "hidden") || strings.Contains(text, "covert") {
+		t.Fatalf("secrets leaked: %s", text)
+	}
+	if !strings.Contains(text, "db:5432") {
+		t.Fatalf("database host expected: %s", text)
+	}
+}
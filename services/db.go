@@ -1,44 +1,91 @@
 package services
 
 import (
+	"context"
 	"database/sql"
-	"embed"
-	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
+	"time"
 
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
-func migrationScheme(db *sql.DB, migrations embed.FS) {
-	d, err := iofs.New(migrations, "migrations")
-	if err != nil {
-		slog.Error("Can't open migration resource", slog.String("err", err.Error()))
-	}
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
-	if err != nil {
-		slog.Error("Can't create postgres instance", slog.String("err", err.Error()))
-		return
+const (
+	// connectBackoff начальная пауза между попытками подключения к базе
+	connectBackoff = 100 * time.Millisecond
+	// connectMaxBackoff наибольшая пауза между попытками подключения к базе
+	connectMaxBackoff = 5 * time.Second
+)
+
+// waitDatabase ожидает доступности базы, повторяя Ping с растущей паузой,
+// пока не истечет timeout или не будет отменен ctx
+func waitDatabase(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	backoff := connectBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		slog.WarnContext(ctx, "Database is not available", slog.Int("attempt", attempt),
+			slog.Duration("retry", backoff), slog.String("err", err.Error()))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database is not available after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, connectMaxBackoff)
 	}
-	instance, err := migrate.NewWithInstance("iofs", d, "pet", driver)
+}
+
+// OpenDatabase подключается к Postgres с настройками пула из cfg.Database и
+// ожидает его доступности не дольше cfg.Database.ConnectTimeout
+func OpenDatabase(ctx context.Context, cfg *Config) (*sql.DB, error) {
+	db, err := otelsql.Open("postgres", cfg.Database.Url,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}))
 	if err != nil {
-		slog.Error("Can't create migration", slog.String("err", err.Error()))
-		return
+		return nil, fmt.Errorf("open postgres connection: %w", err)
 	}
-	err = instance.Up()
-	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		slog.Error("Can't up migration", slog.String("err", err.Error()))
-		return
+	db.SetMaxOpenConns(cfg.Database.MaxOpen)
+	db.SetMaxIdleConns(cfg.Database.MaxIdle)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.Database.ConnMaxIdleTime)
+	if err = waitDatabase(ctx, db, cfg.Database.ConnectTimeout); err != nil {
+		_ = db.Close()
+		return nil, err
 	}
+	return db, nil
 }
 
-func NewDatabase(migrations embed.FS) (*sql.DB, error) {
-	db, err := sql.Open("postgres", PostgresUrl)
+// NewDatabase подключается к базе через OpenDatabase и применяет migrations
+// схемы сервиса cfg.Service. Ошибка миграции прерывает запуск сервиса.
+func NewDatabase(ctx context.Context, cfg *Config, migrations fs.FS) (*sql.DB, error) {
+	db, err := OpenDatabase(ctx, cfg)
 	if err != nil {
-		slog.Error("Can't open postgres connection", slog.String("err", err.Error()))
 		return nil, err
 	}
-	migrationScheme(db, migrations)
+	if err = migrationScheme(ctx, db, cfg.Service, migrations); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	RegisterDBStats(db, cfg.Service)
 	return db, nil
 }
+
+// DBStatsValue представление статистики пула подключений для журнала
+func DBStatsValue(db *sql.DB) slog.Value {
+	s := db.Stats()
+	return slog.GroupValue(
+		slog.Int("open", s.OpenConnections),
+		slog.Int("in_use", s.InUse),
+		slog.Int("idle", s.Idle),
+		slog.Int64("wait_count", s.WaitCount),
+		slog.Duration("wait_duration", s.WaitDuration),
+		slog.Int64("max_idle_closed", s.MaxIdleClosed),
+		slog.Int64("max_lifetime_closed", s.MaxLifetimeClosed),
+	)
+}
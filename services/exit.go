@@ -2,19 +2,188 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Phase этап остановки сервиса. Этапы выполняются по возрастанию,
+// обработчики внутри этапа в порядке, обратном регистрации.
+type Phase int
+
+const (
+	// PhaseStopAccepting сервис перестает считаться готовым и принимать новые запросы
+	PhaseStopAccepting Phase = iota
+	// PhaseShutdown серверы завершают обработку текущих запросов
+	PhaseShutdown
+	// PhaseDrain дожидаемся фоновых задач
+	PhaseDrain
+	// PhaseClose закрываем подключения к базе данных и кешу
+	PhaseClose
+	phases
 )
 
-type ExitHandler func(context.Context)
+func (p Phase) String() string {
+	switch p {
+	case PhaseStopAccepting:
+		return "stop-accepting"
+	case PhaseShutdown:
+		return "shutdown"
+	case PhaseDrain:
+		return "drain"
+	case PhaseClose:
+		return "close"
+	}
+	return fmt.Sprintf("phase(%d)", int(p))
+}
+
+type StopHook func(ctx context.Context) error
+
+type hook struct {
+	name string
+	fn   StopHook
+}
+
+// Lifecycle управляет остановкой сервиса. Корневой контекст отменяется
+// по SIGINT/SIGTERM или вызовом Stop, после чего Wait выполняет
+// зарегистрированные обработчики по этапам с общим ограничением времени.
+type Lifecycle struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	mu      sync.Mutex
+	hooks   [phases][]hook
+	workers sync.WaitGroup
+	once    sync.Once
+	err     error
+}
+
+func NewLifecycle(timeout time.Duration) *Lifecycle {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	l := &Lifecycle{ctx: ctx, cancel: cancel, timeout: timeout}
+	l.OnStop(PhaseDrain, "workers", func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			l.workers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	return l
+}
+
+// Context корневой контекст сервиса, отменяется при начале остановки
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// OnStop регистрирует обработчик этапа остановки phase
+func (l *Lifecycle) OnStop(phase Phase, name string, fn StopHook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks[phase] = append(l.hooks[phase], hook{name: name, fn: fn})
+}
+
+// Go запускает фоновую задачу, которую остановка дожидается на этапе PhaseDrain
+func (l *Lifecycle) Go(name string, fn func(ctx context.Context)) {
+	l.workers.Add(1)
+	go func() {
+		defer l.workers.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				slog.Error("Worker panic", slog.String("worker", name), slog.String("err", fmt.Sprint(err)))
+				l.Stop()
+			}
+		}()
+		fn(l.ctx)
+	}()
+}
+
+// Stop начинает остановку сервиса
+func (l *Lifecycle) Stop() {
+	l.cancel()
+}
+
+// Wait ожидает начала остановки и выполняет обработчики всех этапов
+func (l *Lifecycle) Wait() error {
+	<-l.ctx.Done()
+	// Повторный сигнал завершит процесс немедленно
+	l.cancel()
+	l.once.Do(func() {
+		l.err = l.shutdown()
+	})
+	return l.err
+}
 
-func ExitHandle(handler ExitHandler) context.Context {
-	parent := context.Background()
+func (l *Lifecycle) shutdown() error {
+	slog.Info("Service stopping", slog.Duration("timeout", l.timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	l.mu.Lock()
+	hooks := l.hooks
+	l.mu.Unlock()
+	var errs []error
+	for phase := Phase(0); phase < phases; phase++ {
+		for i := len(hooks[phase]) - 1; i >= 0; i-- {
+			h := hooks[phase][i]
+			start := time.Now()
+			if err := h.fn(ctx); err != nil {
+				slog.Error("Stop hook failed", slog.String("phase", phase.String()),
+					slog.String("hook", h.name), slog.String("err", err.Error()))
+				errs = append(errs, fmt.Errorf("%s %s: %w", phase, h.name, err))
+				continue
+			}
+			slog.Debug("Stop hook done", slog.String("phase", phase.String()),
+				slog.String("hook", h.name), slog.Duration("elapsed", time.Since(start)))
+		}
+	}
+	slog.Info("Service exit")
+	return errors.Join(errs...)
+}
+
+// ServeGRPC обслуживает listener сервером server до остановки lifecycle.
+// При остановке сервер завершает текущие вызовы через GracefulStop, а по
+// истечении срока остановки прерывает оставшиеся вызовы через Stop.
+func ServeGRPC(l *Lifecycle, server *grpc.Server, listener net.Listener) {
+	l.OnStop(PhaseShutdown, "grpc", func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			server.Stop()
+			<-done
+			return ctx.Err()
+		}
+	})
 	go func() {
-		ctx, cancel := signal.NotifyContext(parent, syscall.SIGINT, syscall.SIGTERM)
-		defer cancel()
-		<-ctx.Done()
-		handler(ctx)
+		slog.Info("Starting server", slog.String("addr", listener.Addr().String()))
+		if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			slog.Error("Failed to serve", slog.String("err", err.Error()))
+		}
+		l.Stop()
 	}()
-	return parent
+}
+
+// Closer обработчик этапа остановки для ресурса с методом Close
+func Closer(c interface{ Close() error }) StopHook {
+	return func(context.Context) error {
+		return c.Close()
+	}
 }
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestLifecycle_Order(t *testing.T) {
+	l := NewLifecycle(time.Second)
+	var mu sync.Mutex
+	var calls []string
+	var drained atomic.Bool
+	record := func(name string) StopHook {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if name == "cache" && !drained.Load() {
+				name += "-before-worker"
+			}
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	l.OnStop(PhaseClose, "database", record("database"))
+	l.OnStop(PhaseClose, "cache", record("cache"))
+	l.OnStop(PhaseShutdown, "grpc", record("grpc"))
+	l.OnStop(PhaseStopAccepting, "health", record("health"))
+	l.Go("worker", func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		drained.Store(true)
+	})
+	l.Stop()
+	if err := l.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if actual := strings.Join(calls, ","); actual != "health,grpc,cache,database" {
+		t.Fatalf("unexpected order %s", actual)
+	}
+}
+
+func TestLifecycle_ContextCancelled(t *testing.T) {
+	l := NewLifecycle(time.Second)
+	ctx := l.Context()
+	l.Stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("root context is not cancelled")
+	}
+	_ = l.Wait()
+}
+
+func TestLifecycle_Timeout(t *testing.T) {
+	l := NewLifecycle(50 * time.Millisecond)
+	closed := false
+	l.OnStop(PhaseShutdown, "stuck", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	l.OnStop(PhaseClose, "database", func(context.Context) error {
+		closed = true
+		return nil
+	})
+	l.Stop()
+	err := l.Wait()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if !closed {
+		t.Fatal("close hooks must run after a failed phase")
+	}
+	if again := l.Wait(); !errors.Is(again, context.DeadlineExceeded) {
+		t.Fatalf("Wait must return the same result, got %v", again)
+	}
+}
+
+func TestServeGRPC_GracefulStop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLifecycle(time.Second)
+	ServeGRPC(l, grpc.NewServer(), listener)
+	l.Stop()
+	if err = l.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = net.DialTimeout("tcp", listener.Addr().String(), 100*time.Millisecond); err == nil {
+		t.Fatal("listener must be closed")
+	}
+}
@@ -2,21 +2,319 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"runtime/debug"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
-func PrintMetadata(context context.Context) {
-	if slog.Default().Enabled(context, slog.LevelDebug) {
-		md, ok := metadata.FromIncomingContext(context)
-		if ok {
-			var args = make([]any, 0)
-			for key, values := range md {
-				args = append(args, slog.String(key, strings.Join(values, ";")))
+// RequestIdHeader заголовок метаданных с идентификатором запроса. Принимается
+// от клиента или создается сервером и возвращается в заголовках ответа.
+const RequestIdHeader = "x-request-id"
+
+// call сведения о текущем вызове gRPC, которые журнал добавляет к записям
+type call struct {
+	requestId string
+	method    string
+	userId    atomic.Value
+}
+
+type callKey struct{}
+
+func callFrom(ctx context.Context) *call {
+	c, _ := ctx.Value(callKey{}).(*call)
+	return c
+}
+
+// RequestId идентификатор текущего запроса или пустая строка вне вызова gRPC
+func RequestId(ctx context.Context) string {
+	if c := callFrom(ctx); c != nil {
+		return c.requestId
+	}
+	return ""
+}
+
+// UserId пользователь текущего вызова или пустая строка, если он неизвестен
+func UserId(ctx context.Context) string {
+	if c := callFrom(ctx); c != nil {
+		id, _ := c.userId.Load().(string)
+		return id
+	}
+	return ""
+}
+
+// SetUserId запоминает пользователя текущего вызова для записей журнала.
+// Для запросов с полем user_id он определяется автоматически.
+func SetUserId(ctx context.Context, id string) {
+	if c := callFrom(ctx); c != nil && id != "" {
+		c.userId.Store(id)
+	}
+}
+
+// NewGRPCServer создает сервер gRPC, по cfg.Tls принимающий соединения TLS,
+// с общей цепочкой перехватчиков:
+// идентификатор запроса и журнал, метрики, аутентификация по cfg.Auth,
+// ограничение частоты limiter, срок выполнения по умолчанию и
+// восстановление после паники обработчика в codes.Internal. Неудачные
+// попытки аутентификации limiter ограничивает до нее. limiter может быть nil.
+// Вызовы трассируются otelgrpc, кроме проверок grpc.health.v1.
+func NewGRPCServer(cfg *Config, limiter *RateLimiter, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	i := newInterceptors(cfg)
+	var err error
+	if i.auth, err = newAuthenticator(cfg.Auth); err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+	if cfg.Tls.Enabled() {
+		c, err := NewServerTls(cfg.Tls)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		opts = append([]grpc.ServerOption{grpc.Creds(credentials.NewTLS(c))}, opts...)
+	}
+	opts = append([]grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithFilter(filters.Not(filters.HealthCheck())),
+			// Длительность вызовов учитывают перехватчики, метрики otelgrpc не нужны
+			otelgrpc.WithMeterProvider(noop.NewMeterProvider()),
+		)),
+		grpc.ChainUnaryInterceptor(i.unary(limiter)...),
+		grpc.ChainStreamInterceptor(i.stream(limiter)...),
+	}, opts...)
+	return grpc.NewServer(opts...), nil
+}
+
+type interceptors struct {
+	deadline time.Duration
+	auth     *authenticator
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+}
+
+func newInterceptors(cfg *Config) *interceptors {
+	meter := otel.Meter("pet/services")
+	duration, err := meter.Float64Histogram("rpc.server.duration",
+		metric.WithDescription("Duration of gRPC calls"), metric.WithUnit("ms"))
+	if err != nil {
+		slog.Error("Can't create rpc duration histogram", slog.String("err", err.Error()))
+	}
+	errs, err := meter.Int64Counter("rpc.server.errors",
+		metric.WithDescription("Number of gRPC calls finished with an error"))
+	if err != nil {
+		slog.Error("Can't create rpc errors counter", slog.String("err", err.Error()))
+	}
+	return &interceptors{deadline: cfg.Grpc.Deadline, duration: duration, errors: errs}
+}
+
+func (i *interceptors) unary(limiter *RateLimiter) []grpc.UnaryServerInterceptor {
+	chain := []grpc.UnaryServerInterceptor{i.logUnary, i.metricsUnary}
+	if limiter.enabled() && i.auth != nil {
+		chain = append(chain, limiter.guardUnary)
+	}
+	chain = append(chain, i.authUnary)
+	if limiter.enabled() {
+		chain = append(chain, limiter.unary)
+	}
+	return append(chain, i.deadlineUnary, i.recoverUnary)
+}
+
+func (i *interceptors) stream(limiter *RateLimiter) []grpc.StreamServerInterceptor {
+	chain := []grpc.StreamServerInterceptor{i.logStream, i.metricsStream}
+	if limiter.enabled() && i.auth != nil {
+		chain = append(chain, limiter.guardStream)
+	}
+	chain = append(chain, i.authStream)
+	if limiter.enabled() {
+		chain = append(chain, limiter.stream)
+	}
+	return append(chain, i.deadlineStream, i.recoverStream)
+}
+
+// wrappedStream подменяет контекст серверного потока
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *wrappedStream) Context() context.Context {
+	return s.ctx
+}
+
+func withContext(stream grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	if ctx == stream.Context() {
+		return stream
+	}
+	return &wrappedStream{ServerStream: stream, ctx: ctx}
+}
+
+// secretHeaders заголовки с учетными данными, значения которых не пишутся
+// в журнал
+var secretHeaders = map[string]bool{
+	authorizationHeader:   true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"x-api-key":           true,
+}
+
+// begin назначает вызову идентификатор запроса и отправляет его клиенту
+func begin(ctx context.Context, method string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id string
+	if values := md.Get(RequestIdHeader); len(values) > 0 && values[0] != "" {
+		id = values[0]
+	} else {
+		id = uuid.NewString()
+	}
+	ctx = context.WithValue(ctx, callKey{}, &call{requestId: id, method: method})
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIdHeader, id))
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		var args []any
+		for key, values := range md {
+			value := strings.Join(values, ";")
+			if secretHeaders[key] {
+				value = "REDACTED"
 			}
-			slog.Debug("Metadata", args...)
+			args = append(args, slog.String(key, value))
 		}
+		slog.DebugContext(ctx, "Metadata", args...)
+	}
+	return ctx
+}
+
+func finish(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	args := []any{
+		slog.String("code", code.String()),
+		slog.Duration("elapsed", time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		args = append(args, slog.String("peer", p.Addr.String()))
+	}
+	switch {
+	case code == codes.OK && strings.HasPrefix(method, "/grpc.health.v1."):
+		slog.DebugContext(ctx, "Call finished", args...)
+	case code == codes.OK:
+		slog.InfoContext(ctx, "Call finished", args...)
+	case code == codes.Internal, code == codes.Unknown, code == codes.DataLoss, code == codes.Unavailable:
+		slog.ErrorContext(ctx, "Call failed", append(args, slog.String("err", err.Error()))...)
+	default:
+		slog.WarnContext(ctx, "Call failed", append(args, slog.String("err", err.Error()))...)
+	}
+}
+
+func (i *interceptors) logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx = begin(ctx, info.FullMethod)
+	if r, ok := req.(interface{ GetUserId() string }); ok {
+		SetUserId(ctx, r.GetUserId())
+	}
+	reply, err := handler(ctx, req)
+	finish(ctx, info.FullMethod, start, err)
+	return reply, err
+}
+
+func (i *interceptors) logStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx := begin(stream.Context(), info.FullMethod)
+	err := handler(srv, withContext(stream, ctx))
+	finish(ctx, info.FullMethod, start, err)
+	return err
+}
+
+func (i *interceptors) record(ctx context.Context, method string, start time.Time, err error) {
+	attrs := metric.WithAttributes(
+		attribute.String("rpc.method", method),
+		attribute.String("rpc.grpc.status_code", status.Code(err).String()),
+	)
+	if i.duration != nil {
+		i.duration.Record(ctx, float64(time.Since(start))/float64(time.Millisecond), attrs)
+	}
+	if err != nil && i.errors != nil {
+		i.errors.Add(ctx, 1, attrs)
+	}
+}
+
+func (i *interceptors) metricsUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	reply, err := handler(ctx, req)
+	i.record(ctx, info.FullMethod, start, err)
+	return reply, err
+}
+
+func (i *interceptors) metricsStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, stream)
+	i.record(stream.Context(), info.FullMethod, start, err)
+	return err
+}
+
+// withDeadline ограничивает вызов сроком по умолчанию, если клиент не передал свой
+func (i *interceptors) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || i.deadline <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, i.deadline)
+}
+
+func (i *interceptors) deadlineUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, cancel := i.withDeadline(ctx)
+	defer cancel()
+	return handler(ctx, req)
+}
+
+// longLived потоки, которые клиент держит открытыми, пока ему нужны
+// изменения: наблюдение за состоянием и reflection
+func longLived(method string) bool {
+	return method == healthpb.Health_Watch_FullMethodName ||
+		strings.HasPrefix(method, "/grpc.reflection.")
+}
+
+func (i *interceptors) deadlineStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if longLived(info.FullMethod) {
+		return handler(srv, stream)
 	}
+	ctx, cancel := i.withDeadline(stream.Context())
+	defer cancel()
+	return handler(srv, withContext(stream, ctx))
+}
+
+func recovered(ctx context.Context, value any) error {
+	slog.ErrorContext(ctx, "Recovered from panic",
+		slog.String("stack", string(debug.Stack())),
+		slog.String("err", fmt.Sprint(value)))
+	return status.Error(codes.Internal, "internal error")
+}
+
+func (i *interceptors) recoverUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (reply any, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			reply, err = nil, recovered(ctx, value)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func (i *interceptors) recoverStream(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = recovered(stream.Context(), value)
+		}
+	}()
+	return handler(srv, stream)
 }
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var unaryInfo = &grpc.UnaryServerInfo{FullMethod: "/pet.Test/Call"}
+
+func TestInterceptors_Recover(t *testing.T) {
+	cfg := DefaultConfig("test", 50051)
+	i := newInterceptors(&cfg)
+	_, err := i.recoverUnary(context.Background(), nil, unaryInfo, func(context.Context, any) (any, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("internal error expected, got %v", err)
+	}
+}
+
+func TestInterceptors_Deadline(t *testing.T) {
+	cfg := DefaultConfig("test", 50051)
+	cfg.Grpc.Deadline = time.Minute
+	i := newInterceptors(&cfg)
+	deadline := func(ctx context.Context) time.Duration {
+		var left time.Duration
+		_, _ = i.deadlineUnary(ctx, nil, unaryInfo, func(ctx context.Context, _ any) (any, error) {
+			d, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("deadline expected")
+			}
+			left = time.Until(d)
+			return nil, nil
+		})
+		return left
+	}
+	if left := deadline(context.Background()); left <= 50*time.Second || left > time.Minute {
+		t.Fatalf("default deadline expected, got %s", left)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if left := deadline(ctx); left > time.Second {
+		t.Fatalf("client deadline must be kept, got %s", left)
+	}
+	for method, limited := range map[string]bool{
+		"/pet.Test/Stream":                                          true,
+		healthpb.Health_Watch_FullMethodName:                        false,
+		"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo": false,
+	} {
+		info := &grpc.StreamServerInfo{FullMethod: method, IsServerStream: true}
+		_ = i.deadlineStream(nil, &wrappedStream{ctx: context.Background()}, info, func(_ any, stream grpc.ServerStream) error {
+			if _, ok := stream.Context().Deadline(); ok != limited {
+				t.Fatalf("%s: deadline %v expected", method, limited)
+			}
+			return nil
+		})
+	}
+}
+
+func TestNewGRPCServer_RequestId(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig("test", 50051)
+	server, err := NewGRPCServer(&cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	client := healthpb.NewHealthClient(conn)
+	requestId := func(ctx context.Context) string {
+		var header metadata.MD
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header)); err != nil {
+			t.Fatal(err)
+		}
+		if values := header.Get(RequestIdHeader); len(values) == 1 {
+			return values[0]
+		}
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if id := requestId(ctx); id == "" {
+		t.Fatal("generated request id expected")
+	}
+	if id := requestId(metadata.AppendToOutgoingContext(ctx, RequestIdHeader, "req-1")); id != "req-1" {
+		t.Fatalf("client request id expected, got %q", id)
+	}
+}
+
+func TestBegin_RedactsCredentials(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var out bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(authorizationHeader, "Bearer secret-token", "user-agent", "petctl"))
+	begin(ctx, "/pet.WhoAmI/Check")
+	if logged := out.String(); strings.Contains(logged, "secret-token") || !strings.Contains(logged, "petctl") {
+		t.Fatalf("authorization must be redacted from metadata, got %s", logged)
+	}
+}
@@ -35,3 +35,8 @@ func CreateChain(tokenData []byte, passphrase string) Chain {
 func CreateEmptyChain(tok string, length uint64) Chain {
 	return hasqchain.CreateEmptyChain(tok, length)
 }
+
+// Hash returns the hash of the concatenated parameters used by chains.
+func Hash(params ...any) string {
+	return hasqchain.Hash(params...)
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+const (
+	healthCheckInterval = 5 * time.Second
+	healthCheckTimeout  = 2 * time.Second
+
+	serving    = healthpb.HealthCheckResponse_SERVING
+	notServing = healthpb.HealthCheckResponse_NOT_SERVING
+)
+
+// ReadinessCheck проверка доступности зависимости сервиса
+type ReadinessCheck func(ctx context.Context) error
+
+// Health состояние сервиса для grpc.health.v1 и HTTP проб /healthz и /readyz
+type Health struct {
+	server   *health.Server
+	names    []string
+	mu       sync.Mutex
+	checks   map[string]ReadinessCheck
+	stopping atomic.Bool
+}
+
+// RegisterHealth регистрирует grpc.health.v1 и reflection на server, а пробы на mux.
+// Вызывается после регистрации сервисов, их имена получают общий статус.
+// Готовность пересчитывается периодически до остановки l, на этапе
+// PhaseStopAccepting сервис помечается как NOT_SERVING.
+func RegisterHealth(l *Lifecycle, server *grpc.Server, mux *http.ServeMux) *Health {
+	h := &Health{server: health.NewServer(), checks: make(map[string]ReadinessCheck)}
+	for name := range server.GetServiceInfo() {
+		h.names = append(h.names, name)
+	}
+	healthpb.RegisterHealthServer(server, h.server)
+	reflection.Register(server)
+	mux.HandleFunc("/healthz", h.liveness)
+	mux.HandleFunc("/readyz", h.readiness)
+	h.update(notServing)
+	l.Go("health", func(ctx context.Context) {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		for {
+			h.refresh(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+	l.OnStop(PhaseStopAccepting, "health", func(context.Context) error {
+		h.stopping.Store(true)
+		h.server.Shutdown()
+		return nil
+	})
+	return h
+}
+
+// AddCheck добавляет проверку готовности с именем name
+func (h *Health) AddCheck(name string, check ReadinessCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// Check выполняет все проверки готовности, возвращает ошибки по именам проверок
+func (h *Health) Check(ctx context.Context) map[string]error {
+	h.mu.Lock()
+	checks := make(map[string]ReadinessCheck, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	result := make(map[string]error, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check(ctx)
+			mu.Lock()
+			result[name] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if h.stopping.Load() {
+		result["lifecycle"] = fmt.Errorf("service is stopping")
+	}
+	return result
+}
+
+func (h *Health) update(status healthpb.HealthCheckResponse_ServingStatus) {
+	h.server.SetServingStatus("", status)
+	for _, name := range h.names {
+		h.server.SetServingStatus(name, status)
+	}
+}
+
+func (h *Health) refresh(ctx context.Context) {
+	if h.stopping.Load() {
+		return
+	}
+	status := serving
+	for name, err := range h.Check(ctx) {
+		if err != nil {
+			slog.Warn("Readiness check failed", slog.String("check", name), slog.String("err", err.Error()))
+			status = notServing
+		}
+	}
+	h.update(status)
+}
+
+func (h *Health) liveness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (h *Health) readiness(w http.ResponseWriter, r *http.Request) {
+	result := h.Check(r.Context())
+	reply := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{Status: "ok", Checks: make(map[string]string, len(result))}
+	code := http.StatusOK
+	for name, err := range result {
+		if err != nil {
+			reply.Checks[name] = err.Error()
+			reply.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		} else {
+			reply.Checks[name] = "ok"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(reply)
+}
+
+// Probe проверяет готовность запущенного сервиса через /readyz порта метрик,
+// возвращает код завершения процесса. Используется в healthcheck контейнера.
+func Probe(cfg *Config) int {
+	client := http.Client{Timeout: healthCheckTimeout + time.Second}
+	response, err := client.Get("http://127.0.0.1:" + strconv.Itoa(cfg.Metrics.Port) + "/readyz")
+	if err != nil {
+		slog.Error("Probe failed", slog.String("err", err.Error()))
+		return 1
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		slog.Error("Service is not ready", slog.Int("status", response.StatusCode))
+		return 1
+	}
+	return 0
+}
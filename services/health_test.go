@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealth_Readiness(t *testing.T) {
+	l := NewLifecycle(time.Second)
+	mux := http.NewServeMux()
+	h := RegisterHealth(l, grpc.NewServer(), mux)
+	var failure atomic.Pointer[error]
+	h.AddCheck("postgres", func(context.Context) error {
+		if err := failure.Load(); err != nil {
+			return *err
+		}
+		return nil
+	})
+
+	probe := func(path string) int {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+	if code := probe("/readyz"); code != http.StatusOK {
+		t.Fatalf("ready expected, got %d", code)
+	}
+	refused := errors.New("connection refused")
+	failure.Store(&refused)
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("not ready expected, got %d", code)
+	}
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Fatalf("liveness must not depend on checks, got %d", code)
+	}
+
+	failure.Store(nil)
+	l.Stop()
+	if err := l.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("not ready expected while stopping, got %d", code)
+	}
+}
+
+func TestHealth_GRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLifecycle(time.Second)
+	server := grpc.NewServer()
+	h := RegisterHealth(l, server, http.NewServeMux())
+	h.AddCheck("postgres", func(context.Context) error {
+		return nil
+	})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	client := healthpb.NewHealthClient(conn)
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		reply, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply.GetStatus()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for status() != healthpb.HealthCheckResponse_SERVING {
+		if time.Now().After(deadline) {
+			t.Fatal("service is not serving")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.Stop()
+	if err = l.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if actual := status(); actual != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("not serving expected after stop, got %s", actual)
+	}
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jwtLeeway допустимое расхождение часов при проверке exp и nbf
+const jwtLeeway = time.Minute
+
+var (
+	ErrJwtInvalid = errors.New("invalid jwt")
+	ErrJwtExpired = errors.New("jwt expired")
+)
+
+// jwtHashes алгоритмы подписи JWT и их хеш-функции. Алгоритм none и ключи
+// другого типа не принимаются, чтобы открытый ключ RS нельзя было выдать
+// за секрет HS.
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// Claims проверенные утверждения JWT
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Roles     []string `json:"roles"`
+}
+
+// audience поле aud, которое бывает строкой или списком строк
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// jwk ключ из набора JWKS: RSA (n, e) или симметричный oct (k)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// jwtVerifier проверяет подпись и сроки JWT по секрету HS и ключам из JWKS
+type jwtVerifier struct {
+	secrets  map[string][]byte
+	keys     map[string]*rsa.PublicKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+func newJwtVerifier(cfg AuthConfig) (*jwtVerifier, error) {
+	v := &jwtVerifier{
+		secrets:  make(map[string][]byte),
+		keys:     make(map[string]*rsa.PublicKey),
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		now:      time.Now,
+	}
+	if cfg.JwtSecret != "" {
+		v.secrets[""] = []byte(cfg.JwtSecret)
+	}
+	if cfg.JwksFile != "" {
+		if err := v.loadJwks(cfg.JwksFile); err != nil {
+			return nil, fmt.Errorf("jwks %s: %w", cfg.JwksFile, err)
+		}
+	}
+	if len(v.secrets) == 0 && len(v.keys) == 0 {
+		return nil, errors.New("no jwt keys")
+	}
+	return v, nil
+}
+
+func (v *jwtVerifier) loadJwks(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+	for _, key := range set.Keys {
+		switch key.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				return fmt.Errorf("invalid rsa key %q", key.Kid)
+			}
+			public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			if public.N.BitLen() < 2048 {
+				return fmt.Errorf("rsa key %q is shorter than 2048 bits", key.Kid)
+			}
+			v.keys[key.Kid] = public
+		case "oct":
+			k, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil || len(k) == 0 {
+				return fmt.Errorf("invalid oct key %q", key.Kid)
+			}
+			v.secrets[key.Kid] = k
+		default:
+			return fmt.Errorf("unsupported key type %q of key %q", key.Kty, key.Kid)
+		}
+	}
+	return nil
+}
+
+// decodeSegment разбирает часть JWT в base64url в value
+func decodeSegment(segment string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// Verify проверяет подпись, срок действия, издателя и получателя токена
+func (v *jwtVerifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrJwtInvalid)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrJwtInvalid, err)
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrJwtInvalid, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrJwtInvalid, err)
+	}
+	if strings.HasPrefix(header.Alg, "HS") {
+		secret, ok := v.secrets[header.Kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrJwtInvalid, header.Kid)
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, fmt.Errorf("%w: signature mismatch", ErrJwtInvalid)
+		}
+	} else {
+		key, ok := v.keys[header.Kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrJwtInvalid, header.Kid)
+		}
+		h := hash.New()
+		h.Write([]byte(parts[0] + "." + parts[1]))
+		if err = rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), signature); err != nil {
+			return nil, fmt.Errorf("%w: signature mismatch", ErrJwtInvalid)
+		}
+	}
+
+	var claims Claims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrJwtInvalid, err)
+	}
+	now := v.now()
+	if claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("%w: no expiration", ErrJwtInvalid)
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return nil, ErrJwtExpired
+	}
+	if claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrJwtInvalid)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrJwtInvalid)
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrJwtInvalid, claims.Issuer)
+	}
+	if v.audience != "" && !slices.Contains(claims.Audience, v.audience) {
+		return nil, fmt.Errorf("%w: audience %v", ErrJwtInvalid, []string(claims.Audience))
+	}
+	return &claims, nil
+}
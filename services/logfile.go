@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotatedSuffixFormat = "20060102T150405.000000"
+
+// rotatingFile файл журнала, который переименовывается в path.<время> и
+// открывается заново, когда превышает maxSize байт или старше interval.
+// Хранятся последние backups ротированных файлов.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	interval time.Duration
+	backups  int
+	now      func() time.Time
+	file     *os.File
+	size     int64
+	opened   time.Time
+	closed   bool
+}
+
+func openRotatingFile(path string, maxSize int64, interval time.Duration, backups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, interval: interval, backups: backups, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), f.now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.expired(len(p)) {
+		if err := f.rotate(); err != nil {
+			// Запись журнала важнее ротации, она продолжается в текущий файл. Ошибка
+			// пишется в stderr: журнал сервиса пишет в этот файл и ждал бы f.mu
+			_, _ = fmt.Fprintf(os.Stderr, "Can't rotate log file %s: %v\n", f.path, err)
+		}
+	}
+	// Файл, который не удалось открыть при ротации, открывается при каждой записи
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) expired(next int) bool {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(next) > f.maxSize {
+		return true
+	}
+	return f.interval > 0 && f.now().Sub(f.opened) >= f.interval
+}
+
+// rotate переименовывает файл и открывает новый по тому же пути. Если
+// переименовать не удалось, запись продолжается в прежний файл.
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		err = os.Rename(f.path, f.path+"."+f.now().Format(rotatedSuffixFormat))
+	}
+	if openErr := f.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	if err != nil {
+		return err
+	}
+	return f.prune()
+}
+
+// prune удаляет самые старые ротированные файлы сверх backups. Другие файлы
+// с тем же префиксом, например архивы, не трогаются.
+func (f *rotatingFile) prune() error {
+	if f.backups <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	var rotated []string
+	for _, name := range matches {
+		suffix := strings.TrimPrefix(name, f.path+".")
+		if _, err = time.Parse(rotatedSuffixFormat, suffix); err == nil && len(suffix) == len(rotatedSuffixFormat) {
+			rotated = append(rotated, name)
+		}
+	}
+	if len(rotated) <= f.backups {
+		return nil
+	}
+	// Суффикс времени сортируется так же, как строки
+	sort.Strings(rotated)
+	for _, name := range rotated[:len(rotated)-f.backups] {
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
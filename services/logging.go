@@ -1,61 +1,100 @@
 package services
 
 import (
-	"flag"
-	"log"
+	"context"
+	"io"
 	"log/slog"
-	"net/http"
 	"os"
-	"strconv"
 	"time"
 
-	"github.com/arl/statsviz"
 	"github.com/lmittmann/tint"
 	"github.com/mattn/go-colorable"
 )
 
-func DefineLogging() *slog.Logger {
-	var level *slog.Level
-	debugLevel := slog.LevelDebug
-	level = &debugLevel
-	_ = level.UnmarshalText([]byte(LogLevel))
-	opts := &slog.HandlerOptions{Level: level, AddSource: true}
-	var handler slog.Handler = slog.NewTextHandler(os.Stdout, opts)
-	if LogFile != "" {
-		file, err := os.OpenFile(LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+const (
+	// LogFormatAuto JSON в файл, цветной или обычный текст в консоль
+	LogFormatAuto = "auto"
+	LogFormatText = "text"
+	LogFormatJson = "json"
+	LogFormatTint = "tint"
+)
+
+// DefineLogging устанавливает журнал по умолчанию по cfg.Log. Журнал пишется
+// в файл с ротацией, если он задан, иначе в стандартный вывод. Файл журнала
+// закрывается последним на этапе PhaseClose остановки l.
+func DefineLogging(l *Lifecycle, cfg *Config) (*slog.Logger, error) {
+	var out io.Writer = os.Stdout
+	if cfg.Log.File != "" {
+		file, err := openRotatingFile(cfg.Log.File, int64(cfg.Log.MaxSize)<<20, cfg.Log.Rotate, cfg.Log.Backups)
 		if err != nil {
-			handler = slog.NewJSONHandler(file, opts)
-		} else {
-			log.Fatal(err)
+			return nil, err
+		}
+		l.OnStop(PhaseClose, "log", Closer(file))
+		out = file
+	}
+	logger := slog.New(NewLogHandler(out, &cfg.Log))
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// NewLogHandler создает обработчик журнала формата cfg.Format, который
+// дополняет записи сведениями о вызове gRPC и трассировке из контекста
+func NewLogHandler(out io.Writer, cfg *LogConfig) slog.Handler {
+	opts := &slog.HandlerOptions{Level: &cfg.Level, AddSource: true}
+	format := cfg.Format
+	if format == LogFormatAuto || format == "" {
+		switch {
+		case out != os.Stdout:
+			format = LogFormatJson
+		case cfg.Color:
+			format = LogFormatTint
+		default:
+			format = LogFormatText
+		}
+	}
+	var handler slog.Handler
+	switch format {
+	case LogFormatJson:
+		handler = slog.NewJSONHandler(out, opts)
+	case LogFormatTint:
+		if f, ok := out.(*os.File); ok {
+			out = colorable.NewColorable(f)
 		}
-	} else if LogColor != "" {
-		handler = tint.NewHandler(colorable.NewColorable(os.Stdout), &tint.Options{
-			Level:      level,
+		handler = tint.NewHandler(out, &tint.Options{
+			Level:      &cfg.Level,
 			TimeFormat: time.DateTime,
 			AddSource:  true,
+			NoColor:    !cfg.Color,
 		})
+	default:
+		handler = slog.NewTextHandler(out, opts)
 	}
-	logger := slog.New(handler)
-	slog.SetDefault(logger)
-	return logger
+	return contextHandler{handler}
+}
+
+// contextHandler добавляет к записям request_id, method и user_id текущего
+// вызова gRPC и trace_id текущей трассировки
+type contextHandler struct {
+	slog.Handler
 }
 
-func DefineMetrics() {
-	i, err := strconv.Atoi(MetricsPort)
-	if err != nil {
-		slog.Error("Error parsing metrics port",
-			slog.String("port", MetricsPort), slog.String("err", err.Error()))
-		i = 8081
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if c := callFrom(ctx); c != nil {
+		r.AddAttrs(slog.String("request_id", c.requestId), slog.String("method", c.method))
+		if id := UserId(ctx); id != "" {
+			r.AddAttrs(slog.String("user_id", id))
+		}
 	}
-	mPort := flag.Int("mport", i, "Metrics port")
-	mux := http.NewServeMux()
-	err = statsviz.Register(mux)
-	if err != nil {
-		slog.Error("Error registering metrics", slog.String("err", err.Error()))
-	} else {
-		go func() {
-			slog.Info("Metrics listening on port", slog.String("port", strconv.Itoa(*mPort)))
-			_ = http.ListenAndServe(":"+strconv.Itoa(*mPort), mux)
-		}()
+	if traceId := TraceId(ctx); traceId != "" {
+		r.AddAttrs(slog.String("trace_id", traceId))
 	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
 }
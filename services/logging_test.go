@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefineLogging_File(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	cfg := DefaultConfig("test", 50051)
+	cfg.Log.File = filepath.Join(t.TempDir(), "service.log")
+	l := NewLifecycle(time.Second)
+	logger, err := DefineLogging(l, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("Started", slog.Int("port", cfg.Port))
+	l.Stop()
+	if err = l.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cfg.Log.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record map[string]any
+	if err = json.Unmarshal(bytes.Split(data, []byte("\n"))[0], &record); err != nil {
+		t.Fatalf("JSON expected in log file: %s", data)
+	}
+	if record["msg"] != "Started" {
+		t.Fatalf("unexpected record %v", record)
+	}
+}
+
+func TestNewLogHandler_Context(t *testing.T) {
+	var out bytes.Buffer
+	cfg := LogConfig{Level: slog.LevelInfo, Format: LogFormatJson}
+	logger := slog.New(NewLogHandler(&out, &cfg)).With(slog.String("service", "test"))
+	ctx := begin(context.Background(), "/pet.Test/Call")
+	SetUserId(ctx, "user-1")
+	logger.InfoContext(ctx, "Call")
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{
+		"service":    "test",
+		"method":     "/pet.Test/Call",
+		"request_id": RequestId(ctx),
+		"user_id":    "user-1",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Fatalf("%s = %v expected, got %v", key, value, record[key])
+		}
+	}
+}
+
+func rotated(t *testing.T, path string) []string {
+	t.Helper()
+	names, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestRotatingFile_Size(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.log")
+	f, err := openRotatingFile(path, 100, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 10; i++ {
+		if _, err = f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if names := rotated(t, path); len(names) != 2 {
+		t.Fatalf("two backups expected, got %v", names)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 100 {
+		t.Fatalf("current file exceeds max size: %d", info.Size())
+	}
+}
+
+func TestRotatingFile_Interval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.log")
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f, err := openRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.now = func() time.Time { return clock }
+	f.opened = clock
+	_, _ = f.Write([]byte("first\n"))
+	clock = clock.Add(30 * time.Minute)
+	_, _ = f.Write([]byte("second\n"))
+	if names := rotated(t, path); len(names) != 0 {
+		t.Fatalf("no rotation expected before interval, got %v", names)
+	}
+	clock = clock.Add(30 * time.Minute)
+	_, _ = f.Write([]byte("third\n"))
+	_ = f.Close()
+	names := rotated(t, path)
+	if len(names) != 1 {
+		t.Fatalf("one backup expected, got %v", names)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "third\n" {
+		t.Fatalf("new file expected after rotation, got %q", data)
+	}
+}
+
+func TestRotatingFile_Prune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.log")
+	for _, name := range []string{path + ".gz", path + ".bak"} {
+		if err := os.WriteFile(name, []byte("keep"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := openRotatingFile(path, 10, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	for i := 0; i < 5; i++ {
+		_, _ = f.Write([]byte("0123456789\n"))
+	}
+	_ = f.Close()
+	// Одна ротированная копия и два посторонних файла
+	if names := rotated(t, path); len(names) != 3 {
+		t.Fatalf("unrelated files must be kept, got %v", names)
+	}
+}
+
+func TestRotatingFile_RenameFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.log")
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f, err := openRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.now = func() time.Time { return clock }
+	f.opened = clock
+	_, _ = f.Write([]byte("first\n"))
+	clock = clock.Add(time.Hour)
+	// Каталог на месте ротированного файла не дает переименовать журнал
+	if err = os.Mkdir(path+"."+clock.Format(rotatedSuffixFormat), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("second\n")); err != nil {
+		t.Fatalf("write must continue after failed rotation, got %v", err)
+	}
+	_ = f.Close()
+	if _, err = f.Write([]byte("third\n")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("closed file expected, got %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "first\nsecond\n" {
+		t.Fatalf("both records expected in the current file, got %q", data)
+	}
+}
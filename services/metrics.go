@@ -0,0 +1,80 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/arl/statsviz"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// Registry общий реестр метрик Prometheus. В нем собираются метрики среды
+// выполнения Go, процесса, пулов database/sql и все метрики OpenTelemetry
+// (вызовы gRPC, кеш, go-redis), которые публикуются на /metrics.
+var Registry = newRegistry()
+
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return r
+}
+
+// defineMeterProvider направляет глобальные метрики OpenTelemetry в Registry
+var defineMeterProvider = sync.OnceValue(func() error {
+	exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(Registry))
+	if err != nil {
+		return err
+	}
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)))
+	return nil
+})
+
+// DefineMetrics запускает HTTP сервер метрик до этапа PhaseClose остановки l.
+// На сервере доступны графики statsviz и /metrics в формате Prometheus.
+func DefineMetrics(l *Lifecycle, cfg *Config) *http.ServeMux {
+	mux := http.NewServeMux()
+	server := &http.Server{Addr: ":" + strconv.Itoa(cfg.Metrics.Port), Handler: mux}
+	err := statsviz.Register(mux)
+	if err != nil {
+		slog.Error("Error registering metrics", slog.String("err", err.Error()))
+	}
+	if err = defineMeterProvider(); err != nil {
+		slog.Error("Can't create prometheus exporter", slog.String("err", err.Error()))
+	}
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
+	go func() {
+		slog.Info("Metrics listening on port", slog.Int("port", cfg.Metrics.Port))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server error", slog.String("err", err.Error()))
+		}
+	}()
+	l.OnStop(PhaseClose, "metrics", server.Shutdown)
+	return mux
+}
+
+// RegisterDBStats публикует статистику пула подключений db под именем name.
+// Повторная регистрация с тем же именем заменяет прежний пул.
+func RegisterDBStats(db *sql.DB, name string) {
+	collector := collectors.NewDBStatsCollector(db, name)
+	err := Registry.Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		Registry.Unregister(registered.ExistingCollector)
+		err = Registry.Register(collector)
+	}
+	if err != nil {
+		slog.Error("Can't register database stats", slog.String("err", err.Error()))
+	}
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestDefineMetrics_Prometheus(t *testing.T) {
+	cfg := DefaultConfig("test", 50051)
+	cfg.Metrics.Port = freePort(t)
+	l := NewLifecycle(time.Second)
+	mux := DefineMetrics(l, &cfg)
+	defer func() {
+		l.Stop()
+		_ = l.Wait()
+	}()
+
+	counter, err := otel.Meter("pet/services/test").Int64Counter("test.calls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter.Add(context.Background(), 3)
+	db, err := sql.Open("postgres", cfg.Database.Url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	RegisterDBStats(db, "test")
+	RegisterDBStats(db, "test")
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("metrics expected, got %d: %s", recorder.Code, recorder.Body)
+	}
+	body := recorder.Body.String()
+	for _, name := range []string{
+		"go_goroutines",
+		"process_cpu_seconds_total",
+		`go_sql_max_open_connections{db_name="test"}`,
+		"test_calls_total",
+	} {
+		if !strings.Contains(body, name) {
+			t.Errorf("metric %s expected", name)
+		}
+	}
+}
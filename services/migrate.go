@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
+)
+
+// legacyMigrationsTable общая таблица версий, в которую миграции
+// записывались до появления таблиц сервисов
+const legacyMigrationsTable = "schema_migrations"
+
+// MigrationsTable таблица версий миграций схемы сервиса service
+func MigrationsTable(service string) string {
+	return service + "_schema_migrations"
+}
+
+// NewMigrate создает миграцию схемы сервиса service в текущей схеме db.
+// Миграция занимает одно подключение пула до вызова Close.
+func NewMigrate(ctx context.Context, db *sql.DB, service string, migrations fs.FS) (*migrate.Migrate, error) {
+	source, err := iofs.New(migrations, ".")
+	if err != nil {
+		return nil, fmt.Errorf("open migrations: %w", err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	table := MigrationsTable(service)
+	if err = adoptLegacyVersion(ctx, conn, table, source); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("adopt legacy migrations version: %w", err)
+	}
+	// Драйвер, созданный из подключения, закрывает только его, а не весь пул
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{MigrationsTable: table})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("create postgres instance: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", source, service, driver)
+	if err != nil {
+		_ = driver.Close()
+		return nil, fmt.Errorf("create migration: %w", err)
+	}
+	return m, nil
+}
+
+// adoptLegacyVersion переносит версию из общей таблицы schema_migrations в
+// таблицу сервиса table, чтобы развернутые базы не применяли миграции
+// повторно. Версия переносится, только если она есть среди миграций сервиса.
+func adoptLegacyVersion(ctx context.Context, conn *sql.Conn, table string, migrations source.Driver) error {
+	var exists, legacy bool
+	err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL, to_regclass($2) IS NOT NULL",
+		pq.QuoteIdentifier(table), legacyMigrationsTable).Scan(&exists, &legacy)
+	if err != nil || exists || !legacy {
+		return err
+	}
+	var version int64
+	var dirty bool
+	err = conn.QueryRowContext(ctx, "SELECT version, dirty FROM "+legacyMigrationsTable+" LIMIT 1").
+		Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if version < 0 {
+		return nil
+	}
+	r, _, err := migrations.ReadUp(uint(version))
+	if err != nil {
+		// Версия другого сервиса, разделявшего схему
+		return nil
+	}
+	_ = r.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	quoted := pq.QuoteIdentifier(table)
+	if _, err = tx.ExecContext(ctx, "CREATE TABLE "+quoted+
+		" (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)"); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "INSERT INTO "+quoted+"(version, dirty) VALUES ($1, $2)",
+		version, dirty); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Migrations version adopted from legacy table",
+		slog.String("table", table), slog.Int64("version", version))
+	return tx.Commit()
+}
+
+func migrationScheme(ctx context.Context, db *sql.DB, service string, migrations fs.FS) error {
+	m, err := NewMigrate(ctx, db, service, migrations)
+	if err != nil {
+		return err
+	}
+	defer func() { _, _ = m.Close() }()
+	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("up migration: %w", err)
+	}
+	version, dirty, err := m.Version()
+	if err == nil {
+		slog.InfoContext(ctx, "Database schema migrated", slog.Uint64("version", uint64(version)),
+			slog.Bool("dirty", dirty))
+	}
+	return nil
+}
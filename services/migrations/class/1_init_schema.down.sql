@@ -0,0 +1,16 @@
+DO
+$$
+    DECLARE
+        r RECORD;
+    BEGIN
+        FOR r IN SELECT table_name FROM classes
+            LOOP
+                EXECUTE format('DROP TABLE IF EXISTS %I', r.table_name);
+            END LOOP;
+    END
+$$;
+DROP TABLE class_values_changes;
+DROP TABLE classes;
+DROP FUNCTION IF EXISTS fn_change_value_after_insert();
+DROP FUNCTION IF EXISTS fn_change_value_after_update_status();
+DROP FUNCTION IF EXISTS fn_change_value_after_update_after();
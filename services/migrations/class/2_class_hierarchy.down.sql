@@ -0,0 +1,17 @@
+DO
+$$
+    DECLARE
+        r RECORD;
+    BEGIN
+        FOR r IN SELECT table_name FROM classes
+            LOOP
+                EXECUTE format('DROP TRIGGER IF EXISTS %I ON %I', r.table_name || '_check_parent', r.table_name);
+                EXECUTE format('ALTER TABLE %I DROP COLUMN IF EXISTS parent_key', r.table_name);
+            END LOOP;
+    END
+$$;
+DROP FUNCTION IF EXISTS fn_check_parent_key();
+ALTER TABLE classes
+    DROP COLUMN parent_id;
+ALTER TABLE classes
+    DROP CONSTRAINT classes_pkey;
@@ -0,0 +1,46 @@
+ALTER TABLE classes
+    ADD PRIMARY KEY (id);
+ALTER TABLE classes
+    ADD COLUMN parent_id UUID DEFAULT NULL REFERENCES classes (id); -- Parent class of the elements parent_key
+
+CREATE
+    OR REPLACE FUNCTION fn_check_parent_key() RETURNS TRIGGER AS
+$$
+DECLARE
+    parent_table VARCHAR;
+    parent_found BOOLEAN;
+BEGIN
+    SELECT p.table_name
+    INTO parent_table
+    FROM classes c
+             JOIN classes p ON p.id = c.parent_id
+    WHERE c.table_name = TG_ARGV[0];
+    IF parent_table IS NULL THEN
+        parent_table := TG_ARGV[0];
+    END IF;
+    EXECUTE format('SELECT EXISTS(SELECT 1 FROM %I WHERE key = $1 AND version = $2)', parent_table)
+        INTO parent_found
+        USING NEW.parent_key, NEW.version;
+    IF NOT parent_found THEN
+        RAISE EXCEPTION 'parent key % not found in % version %', NEW.parent_key, parent_table, NEW.version
+            USING ERRCODE = 'foreign_key_violation';
+    END IF;
+    RETURN NEW;
+END;
+$$
+    LANGUAGE 'plpgsql';
+
+DO
+$$
+    DECLARE
+        r RECORD;
+    BEGIN
+        FOR r IN SELECT table_name FROM classes
+            LOOP
+                EXECUTE format('ALTER TABLE %I ADD COLUMN parent_key VARCHAR DEFAULT NULL', r.table_name);
+                EXECUTE format('CREATE TRIGGER %I BEFORE INSERT OR UPDATE OF parent_key, version ON %I FOR EACH ROW ' ||
+                               'WHEN (NEW.parent_key IS NOT NULL) EXECUTE FUNCTION fn_check_parent_key(%L)',
+                               r.table_name || '_check_parent', r.table_name, r.table_name);
+            END LOOP;
+    END
+$$;
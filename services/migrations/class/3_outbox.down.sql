@@ -0,0 +1,24 @@
+CREATE
+    OR REPLACE FUNCTION fn_change_value_after_insert() RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO class_values_changes(class, class_id, version, key, changes, action)
+    VALUES (TG_ARGV[0], NEW.id, NEW.version, NEW.key, NEW.value, 'CREATE');
+    RETURN NEW;
+END;
+$$
+    LANGUAGE 'plpgsql';
+
+CREATE
+    OR REPLACE FUNCTION fn_change_value_after_update_status() RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO class_values_changes(class, class_id, version, key, action, changes)
+    VALUES (TG_ARGV[0], NEW.id, NEW.version, NEW.key, 'STATUS', concat(OLD.status, '->', NEW.status));
+    RETURN NEW;
+END;
+$$
+    LANGUAGE 'plpgsql';
+
+DROP FUNCTION fn_element_published(VARCHAR, VARCHAR, VARCHAR, INTEGER);
+DROP TABLE outbox;
@@ -0,0 +1,55 @@
+CREATE TABLE outbox
+(
+    id           BIGSERIAL   NOT NULL PRIMARY KEY,
+    type         VARCHAR     NOT NULL,
+    aggregate    VARCHAR     NOT NULL,
+    payload      JSONB       NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMPTZ          DEFAULT NULL
+);
+CREATE INDEX outbox_pending ON outbox (id) WHERE published_at IS NULL;
+
+-- Событие ElementPublished записывается триггером в транзакции изменения значения
+CREATE
+    OR REPLACE FUNCTION fn_element_published(class_table VARCHAR, element_key VARCHAR, element_value VARCHAR,
+                                             element_version INTEGER) RETURNS VOID AS
+$$
+DECLARE
+    class_name VARCHAR;
+BEGIN
+    SELECT name INTO class_name FROM classes WHERE table_name = class_table;
+    INSERT INTO outbox(type, aggregate, payload)
+    VALUES ('ElementPublished', concat(class_name, ':', element_key),
+            json_build_object('class', class_name, 'key', element_key, 'value', element_value,
+                              'version', element_version));
+END;
+$$
+    LANGUAGE 'plpgsql';
+
+CREATE
+    OR REPLACE FUNCTION fn_change_value_after_insert() RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO class_values_changes(class, class_id, version, key, changes, action)
+    VALUES (TG_ARGV[0], NEW.id, NEW.version, NEW.key, NEW.value, 'CREATE');
+    IF NEW.status = 'ITEM_PUBLISHED' THEN
+        PERFORM fn_element_published(TG_ARGV[0], NEW.key, NEW.value, NEW.version);
+    END IF;
+    RETURN NEW;
+END;
+$$
+    LANGUAGE 'plpgsql';
+
+CREATE
+    OR REPLACE FUNCTION fn_change_value_after_update_status() RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO class_values_changes(class, class_id, version, key, action, changes)
+    VALUES (TG_ARGV[0], NEW.id, NEW.version, NEW.key, 'STATUS', concat(OLD.status, '->', NEW.status));
+    IF NEW.status = 'ITEM_PUBLISHED' THEN
+        PERFORM fn_element_published(TG_ARGV[0], NEW.key, NEW.value, NEW.version);
+    END IF;
+    RETURN NEW;
+END;
+$$
+    LANGUAGE 'plpgsql';
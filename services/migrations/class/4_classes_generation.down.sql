@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS classes_after_change ON classes;
+DROP TRIGGER IF EXISTS class_values_changes_after_insert ON class_values_changes;
+DROP FUNCTION IF EXISTS fn_change_generation();
+DROP TABLE IF EXISTS class_generation;
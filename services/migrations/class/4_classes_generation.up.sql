@@ -0,0 +1,35 @@
+-- Поколение изменений классов и значений. В отличие от последовательности
+-- class_values_changes_id_seq строка счетчика видна другим транзакциям только
+-- после фиксации, а блокировка строки упорядочивает изменения.
+CREATE TABLE class_generation
+(
+    id    BOOLEAN NOT NULL PRIMARY KEY DEFAULT TRUE CHECK ( id ),
+    value BIGINT  NOT NULL
+);
+-- Начинаем с текущего значения последовательности, чтобы поколение не повторило прежние ключи кеша
+INSERT INTO class_generation(value)
+SELECT CASE WHEN is_called THEN last_value ELSE 0 END
+FROM class_values_changes_id_seq;
+
+CREATE
+    OR REPLACE FUNCTION fn_change_generation() RETURNS TRIGGER AS
+$$
+BEGIN
+    UPDATE class_generation SET value = value + 1;
+    RETURN NULL;
+END;
+$$
+    LANGUAGE 'plpgsql';
+
+CREATE TRIGGER class_values_changes_after_insert
+    AFTER INSERT
+    ON class_values_changes
+    FOR EACH STATEMENT
+EXECUTE FUNCTION fn_change_generation();
+
+-- Изменения классов не попадают в журнал значений, но тоже сдвигают поколение
+CREATE TRIGGER classes_after_change
+    AFTER INSERT OR DELETE OR UPDATE OF name, title, current, status, parent_id
+    ON classes
+    FOR EACH STATEMENT
+EXECUTE FUNCTION fn_change_generation();
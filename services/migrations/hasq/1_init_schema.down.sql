@@ -0,0 +1,13 @@
+DO
+$$
+    DECLARE
+        r RECORD;
+    BEGIN
+        FOR r IN SELECT id FROM tokens
+            LOOP
+                EXECUTE format('DROP TABLE IF EXISTS %I', 'token_' || replace(r.id::text, '-', ''));
+            END LOOP;
+    END
+$$;
+DROP TABLE keys;
+DROP TABLE tokens;
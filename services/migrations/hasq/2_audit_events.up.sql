@@ -0,0 +1,34 @@
+CREATE TABLE audit_events
+(
+    id         BIGSERIAL    NOT NULL PRIMARY KEY,
+    created_at TIMESTAMPTZ  NOT NULL,
+    actor      VARCHAR      NOT NULL,
+    peer       VARCHAR      NOT NULL,
+    method     VARCHAR      NOT NULL,
+    token_id   UUID                  DEFAULT NULL,
+    outcome    VARCHAR(32)  NOT NULL,
+    error      VARCHAR      NOT NULL DEFAULT '',
+    prev_hash  VARCHAR(128) NOT NULL,
+    hash       VARCHAR(128) NOT NULL,
+    UNIQUE (hash)
+);
+CREATE INDEX audit_events_actor ON audit_events (actor, id);
+CREATE INDEX audit_events_token ON audit_events (token_id, id);
+
+CREATE FUNCTION audit_events_append_only() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_update
+    BEFORE UPDATE OR DELETE
+    ON audit_events
+    FOR EACH ROW
+EXECUTE FUNCTION audit_events_append_only();
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE
+    ON audit_events
+    FOR EACH STATEMENT
+EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,10 @@
+CREATE TABLE outbox
+(
+    id           BIGSERIAL   NOT NULL PRIMARY KEY,
+    type         VARCHAR     NOT NULL,
+    aggregate    VARCHAR     NOT NULL,
+    payload      JSONB       NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMPTZ          DEFAULT NULL
+);
+CREATE INDEX outbox_pending ON outbox (id) WHERE published_at IS NULL;
@@ -0,0 +1,41 @@
+// Package migrations содержит миграции схем баз данных сервисов
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+//go:embed class/*.sql hasq/*.sql
+var files embed.FS
+
+var (
+	// Class миграции схемы сервиса class
+	Class = sub("class")
+	// Hasq миграции схемы сервиса hasq
+	Hasq = sub("hasq")
+)
+
+// Services миграции по имени сервиса
+var Services = map[string]fs.FS{
+	"class": Class,
+	"hasq":  Hasq,
+}
+
+// For возвращает миграции сервиса service
+func For(service string) (fs.FS, error) {
+	migrations, ok := Services[service]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for service %q", service)
+	}
+	return migrations, nil
+}
+
+func sub(dir string) fs.FS {
+	migrations, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return migrations
+}
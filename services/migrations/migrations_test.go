@@ -0,0 +1,109 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+
+	"pet/services"
+	"pet/services/migrations"
+	"pet/services/testkit"
+)
+
+// testSchema подключается к новой пустой схеме локального Postgres
+func testSchema(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := services.OpenDatabase(context.Background(), testkit.Config(t, "migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// tables таблицы текущей схемы, кроме таблицы версий миграций
+func tables(t *testing.T, db *sql.DB, service string) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT table_name FROM information_schema.tables
+WHERE table_schema = current_schema() AND table_name <> $1 ORDER BY table_name`,
+		services.MigrationsTable(service))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rows.Close() }()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestMigrations_UpDown(t *testing.T) {
+	for service, source := range migrations.Services {
+		t.Run(service, func(t *testing.T) {
+			db := testSchema(t)
+			m, err := services.NewMigrate(context.Background(), db, service, source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _, _ = m.Close() })
+			if err = m.Up(); err != nil {
+				t.Fatal(err)
+			}
+			last, _, err := m.Version()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Откат по одной миграции проверяет каждую down миграцию отдельно
+			for version := last; version > 0; {
+				if err = m.Steps(-1); err != nil {
+					t.Fatalf("down from %d: %v", version, err)
+				}
+				version, _, err = m.Version()
+				if errors.Is(err, migrate.ErrNilVersion) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if left := tables(t, db, service); len(left) != 0 {
+				t.Fatalf("tables left after rolling back: %v", left)
+			}
+			// Повторное применение проверяет, что откат ничего не оставил
+			if err = m.Up(); err != nil {
+				t.Fatalf("up after down: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewMigrate_LegacyVersion(t *testing.T) {
+	db := testSchema(t)
+	_, err := db.Exec(`CREATE TABLE schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL);
+INSERT INTO schema_migrations VALUES (1, false)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := services.NewMigrate(context.Background(), db, "class", migrations.Class)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = m.Close() })
+	version, dirty, err := m.Version()
+	if err != nil || version != 1 || dirty {
+		t.Fatalf("legacy version 1 expected, got %d, %t, %v", version, dirty, err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(testkit.Run(m))
+}
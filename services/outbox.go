@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// EventTokenCreated создан токен hasq, данные TokenCreated
+	EventTokenCreated = "TokenCreated"
+	// EventOwnershipTransferred пользователь стал владельцем токена, данные OwnershipTransferred
+	EventOwnershipTransferred = "OwnershipTransferred"
+	// EventElementPublished значение класса опубликовано, данные ElementPublished
+	EventElementPublished = "ElementPublished"
+
+	// outboxLock первый ключ pg_advisory_xact_lock, с которым события схемы
+	// публикует только один экземпляр сервиса. Вторым ключом служит хеш
+	// схемы, потому что блокировка действует на всю базу, а схемы сервисов
+	// публикуют события независимо.
+	outboxLock = 0x6f757462
+)
+
+type OutboxConfig struct {
+	// Stream поток Redis для событий сервиса, пустой отключает публикацию
+	Stream string
+	// Interval период опроса таблицы outbox
+	Interval time.Duration
+	// Batch наибольшее число событий в одной транзакции публикации
+	Batch int
+	// MaxLen примерная наибольшая длина потока, 0 без ограничения
+	MaxLen int64
+}
+
+type TokenCreated struct {
+	TokenId string `json:"token_id"`
+	Title   string `json:"title"`
+	Hash    string `json:"hash"`
+}
+
+type OwnershipTransferred struct {
+	TokenId string `json:"token_id"`
+	UserId  string `json:"user_id"`
+	KeyHash string `json:"key_hash"`
+	Num     uint64 `json:"num"`
+}
+
+type ElementPublished struct {
+	Class   string `json:"class"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Version uint32 `json:"version"`
+}
+
+// OutboxEvent событие, записанное в outbox в транзакции изменения. Id вместе
+// с Service однозначно определяет событие. Id выдается при записи, а не при
+// фиксации транзакции, поэтому событие с меньшим Id может быть опубликовано
+// позже события с большим. Потребители отбрасывают повторы по множеству
+// обработанных Id агрегата, а не по наибольшему Id, и упорядочивают события
+// агрегата по данным события: OwnershipTransferred.Num, ElementPublished.Version.
+type OutboxEvent struct {
+	Id        int64
+	Service   string
+	Type      string
+	Aggregate string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// OutboxStore хранилище неопубликованных событий
+type OutboxStore interface {
+	// RelayOutbox передает publish в порядке Id до limit неопубликованных
+	// событий и отмечает опубликованными переданные без ошибки. Возвращает
+	// число опубликованных событий.
+	RelayOutbox(ctx context.Context, limit int, publish func(context.Context, OutboxEvent) error) (int, error)
+}
+
+// WriteOutbox записывает событие в таблицу outbox в транзакции tx, поэтому
+// оно сохраняется тогда и только тогда, когда сохраняется само изменение
+func WriteOutbox(ctx context.Context, tx *sql.Tx, eventType, aggregate string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO outbox(type, aggregate, payload) VALUES ($1, $2, $3)",
+		eventType, aggregate, data)
+	return err
+}
+
+// RelayOutbox реализация OutboxStore.RelayOutbox для таблицы outbox схемы db.
+// Пока транзакцию публикации держит другой экземпляр, ничего не публикуется.
+// Транзакции изменений, еще не зафиксированные к началу публикации, не видны,
+// их события с меньшими Id будут опубликованы следующим вызовом.
+func RelayOutbox(ctx context.Context, db *sql.DB, limit int, publish func(context.Context, OutboxEvent) error) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var locked bool
+	if err = tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1, hashtext(current_schema()))", outboxLock).Scan(&locked); err != nil || !locked {
+		return 0, err
+	}
+	events, err := queryOutbox(ctx, tx, "WHERE published_at IS NULL ORDER BY id LIMIT $1", limit)
+	if err != nil {
+		return 0, err
+	}
+	published := make([]int64, 0, len(events))
+	var publishErr error
+	for _, e := range events {
+		if publishErr = publish(ctx, e); publishErr != nil {
+			break
+		}
+		published = append(published, e.Id)
+	}
+	if len(published) > 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE outbox SET published_at = CURRENT_TIMESTAMP WHERE id = ANY($1)",
+			pq.Array(published))
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(published), publishErr
+}
+
+// OutboxEvents события outbox схемы db с Id от from до to включительно,
+// опубликованные или нет. Нулевой to не ограничивает выборку.
+func OutboxEvents(ctx context.Context, db *sql.DB, from, to int64, eventType string, limit int) ([]OutboxEvent, error) {
+	if to <= 0 {
+		to = 1<<63 - 1
+	}
+	return queryOutbox(ctx, db, "WHERE id >= $1 AND id <= $2 AND ($3 = '' OR type = $3) ORDER BY id LIMIT $4",
+		from, to, eventType, limit)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func queryOutbox(ctx context.Context, q queryer, where string, args ...any) ([]OutboxEvent, error) {
+	rows, err := q.QueryContext(ctx, "SELECT id, type, aggregate, payload, created_at FROM outbox "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var events []OutboxEvent
+	for rows.Next() {
+		var e OutboxEvent
+		var payload []byte
+		if err = rows.Scan(&e.Id, &e.Type, &e.Aggregate, &payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// MemoryOutbox outbox хранилищ в памяти. Хранилище вызывает Write под своей
+// блокировкой вместе с изменением.
+type MemoryOutbox struct {
+	mu     sync.Mutex
+	relay  sync.Mutex
+	events []OutboxEvent
+	// published число опубликованных событий с начала events
+	published int
+}
+
+func (o *MemoryOutbox) Write(eventType, aggregate string, payload any) {
+	data, _ := json.Marshal(payload)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, OutboxEvent{
+		Id: int64(len(o.events)) + 1, Type: eventType, Aggregate: aggregate, Payload: data, CreatedAt: time.Now(),
+	})
+}
+
+func (o *MemoryOutbox) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, OutboxEvent) error) (int, error) {
+	o.relay.Lock()
+	defer o.relay.Unlock()
+	o.mu.Lock()
+	pending := o.events[o.published:min(len(o.events), o.published+limit)]
+	o.mu.Unlock()
+	n := 0
+	var err error
+	for _, e := range pending {
+		if err = publish(ctx, e); err != nil {
+			break
+		}
+		n++
+	}
+	o.mu.Lock()
+	o.published += n
+	o.mu.Unlock()
+	return n, err
+}
+
+// streamValues поля сообщения потока с событием e
+func streamValues(e OutboxEvent) map[string]any {
+	return map[string]any{
+		"id":         strconv.FormatInt(e.Id, 10),
+		"service":    e.Service,
+		"type":       e.Type,
+		"aggregate":  e.Aggregate,
+		"payload":    string(e.Payload),
+		"created_at": e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// parseStreamMessage событие из сообщения потока
+func parseStreamMessage(m redis.XMessage) (OutboxEvent, error) {
+	field := func(name string) string {
+		value, _ := m.Values[name].(string)
+		return value
+	}
+	id, err := strconv.ParseInt(field("id"), 10, 64)
+	if err != nil {
+		return OutboxEvent{}, fmt.Errorf("message %s: invalid event id %q", m.ID, field("id"))
+	}
+	created, err := time.Parse(time.RFC3339Nano, field("created_at"))
+	if err != nil {
+		return OutboxEvent{}, fmt.Errorf("message %s: invalid created_at: %w", m.ID, err)
+	}
+	return OutboxEvent{
+		Id:        id,
+		Service:   field("service"),
+		Type:      field("type"),
+		Aggregate: field("aggregate"),
+		Payload:   json.RawMessage(field("payload")),
+		CreatedAt: created,
+	}, nil
+}
+
+// PublishEvent добавляет событие e в поток stream, ограничивая его длину
+// примерно maxLen сообщениями, если maxLen положителен
+func PublishEvent(ctx context.Context, client *redis.Client, stream string, maxLen int64, e OutboxEvent) error {
+	return client.XAdd(ctx, &redis.XAddArgs{Stream: stream, MaxLen: maxLen, Approx: maxLen > 0, Values: streamValues(e)}).Err()
+}
+
+// OutboxRelay публикует события outbox в поток Redis. Событие отмечается
+// опубликованным только после XADD, поэтому при сбое между ними оно будет
+// опубликовано еще раз: доставка не реже одного раза, без общего порядка.
+type OutboxRelay struct {
+	store     OutboxStore
+	client    *redis.Client
+	service   string
+	cfg       OutboxConfig
+	publish   func(context.Context, OutboxEvent) error
+	published metric.Int64Counter
+	failing   bool
+}
+
+func NewOutboxRelay(cfg *Config, store OutboxStore) (*OutboxRelay, error) {
+	client, err := RedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &OutboxRelay{store: store, client: client, service: cfg.Service, cfg: cfg.Outbox}
+	r.publish = func(ctx context.Context, e OutboxEvent) error {
+		return PublishEvent(ctx, r.client, r.cfg.Stream, r.cfg.MaxLen, e)
+	}
+	r.published, err = otel.Meter("pet/services").Int64Counter("outbox.published",
+		metric.WithDescription("Number of outbox events published to the stream"))
+	if err != nil {
+		slog.Error("Can't create outbox published counter", slog.String("err", err.Error()))
+	}
+	return r, nil
+}
+
+// DefineOutbox публикует события store в поток cfg.Outbox.Stream в фоновой
+// задаче l. С пустым потоком события остаются в outbox.
+func DefineOutbox(l *Lifecycle, cfg *Config, store OutboxStore) error {
+	if cfg.Outbox.Stream == "" {
+		return nil
+	}
+	relay, err := NewOutboxRelay(cfg, store)
+	if err != nil {
+		return err
+	}
+	l.OnStop(PhaseClose, "outbox", Closer(relay))
+	l.Go("outbox", relay.Run)
+	return nil
+}
+
+// Run публикует события с периодом cfg.Outbox.Interval до отмены ctx
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		r.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain публикует накопившиеся события пакетами по cfg.Outbox.Batch
+func (r *OutboxRelay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := r.store.RelayOutbox(ctx, r.cfg.Batch, func(ctx context.Context, e OutboxEvent) error {
+			e.Service = r.service
+			return r.publish(ctx, e)
+		})
+		if n > 0 && r.published != nil {
+			r.published.Add(ctx, int64(n), metric.WithAttributes(attribute.String("stream", r.cfg.Stream)))
+		}
+		if failing := err != nil && ctx.Err() == nil; failing != r.failing {
+			r.failing = failing
+			if failing {
+				slog.WarnContext(ctx, "Can't publish outbox events", slog.String("stream", r.cfg.Stream),
+					slog.String("err", err.Error()))
+			} else {
+				slog.InfoContext(ctx, "Outbox events publishing resumed", slog.String("stream", r.cfg.Stream))
+			}
+		}
+		if err != nil || n < r.cfg.Batch {
+			return
+		}
+	}
+}
+
+func (r *OutboxRelay) Close() error {
+	return r.client.Close()
+}
+
+// StreamConsumer читает поток событий в группе потребителей. Сообщение
+// подтверждается после успешной обработки, необработанные сообщения остаются
+// в группе и после MinIdle забираются любым потребителем группы, поэтому
+// обработчик должен допускать повторы.
+type StreamConsumer struct {
+	Client *redis.Client
+	Stream string
+	Group  string
+	// Name имя потребителя, уникальное в группе
+	Name string
+	// MinIdle время, после которого сообщение упавшего потребителя передается другому
+	MinIdle time.Duration
+	// Block наибольшее время ожидания новых сообщений
+	Block time.Duration
+	// Count наибольшее число сообщений за одно чтение
+	Count int64
+}
+
+// Run создает группу, если ее нет, и передает события handle до отмены ctx.
+// Новая группа читает поток с начала.
+func (c *StreamConsumer) Run(ctx context.Context, handle func(context.Context, OutboxEvent) error) error {
+	minIdle, block, count := c.MinIdle, c.Block, c.Count
+	if minIdle <= 0 {
+		minIdle = time.Minute
+	}
+	if block <= 0 {
+		block = 5 * time.Second
+	}
+	if count <= 0 {
+		count = 100
+	}
+	err := c.Client.XGroupCreateMkStream(ctx, c.Stream, c.Group, "0").Err()
+	if err != nil && !redis.HasErrorPrefix(err, "BUSYGROUP") {
+		return err
+	}
+	// Сначала сообщения, прочитанные этим потребителем до перезапуска, затем новые
+	start := "0"
+	for ctx.Err() == nil {
+		claimed, _, err := c.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream: c.Stream, Group: c.Group, Consumer: c.Name, MinIdle: minIdle, Start: "0", Count: count,
+		}).Result()
+		if err == nil {
+			c.handle(ctx, claimed, handle)
+			var streams []redis.XStream
+			streams, err = c.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group: c.Group, Consumer: c.Name, Streams: []string{c.Stream, start}, Count: count, Block: block,
+			}).Result()
+			for _, s := range streams {
+				c.handle(ctx, s.Messages, handle)
+			}
+			start = ">"
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *StreamConsumer) handle(ctx context.Context, messages []redis.XMessage, handle func(context.Context, OutboxEvent) error) {
+	for _, m := range messages {
+		e, err := parseStreamMessage(m)
+		if err == nil {
+			err = handle(ctx, e)
+		} else {
+			// Сообщение, которое нельзя разобрать, не обработается и при повторе
+			slog.ErrorContext(ctx, "Invalid stream message", slog.String("stream", c.Stream), slog.String("err", err.Error()))
+			err = nil
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Stream event not handled", slog.String("stream", c.Stream),
+				slog.String("type", e.Type), slog.Int64("id", e.Id), slog.String("err", err.Error()))
+			continue
+		}
+		if err = c.Client.XAck(ctx, c.Stream, c.Group, m.ID).Err(); err != nil {
+			slog.WarnContext(ctx, "Can't ack stream message", slog.String("stream", c.Stream),
+				slog.String("message", m.ID), slog.String("err", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryOutbox(t *testing.T) {
+	ctx := context.Background()
+	o := &MemoryOutbox{}
+	for _, id := range []string{"a", "b", "c"} {
+		o.Write(EventTokenCreated, id, TokenCreated{TokenId: id})
+	}
+	var got []string
+	failAt := "b"
+	publish := func(_ context.Context, e OutboxEvent) error {
+		if e.Aggregate == failAt {
+			return errors.New("redis is down")
+		}
+		got = append(got, e.Aggregate)
+		return nil
+	}
+	if n, err := o.RelayOutbox(ctx, 10, publish); n != 1 || err == nil {
+		t.Fatalf("relay must stop at the failed event, got %d %v", n, err)
+	}
+	failAt = ""
+	if n, err := o.RelayOutbox(ctx, 1, publish); n != 1 || err != nil {
+		t.Fatalf("one event expected, got %d %v", n, err)
+	}
+	if n, err := o.RelayOutbox(ctx, 10, publish); n != 1 || err != nil {
+		t.Fatalf("last event expected, got %d %v", n, err)
+	}
+	if n, _ := o.RelayOutbox(ctx, 10, publish); n != 0 {
+		t.Fatalf("published events must not be relayed again, got %d", n)
+	}
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("events in write order expected, got %v", got)
+	}
+}
+
+func TestOutboxRelay_Drain(t *testing.T) {
+	o := &MemoryOutbox{}
+	for i := 0; i < 5; i++ {
+		o.Write(EventOwnershipTransferred, "t", OwnershipTransferred{TokenId: "t", Num: uint64(i)})
+	}
+	var events []OutboxEvent
+	r := &OutboxRelay{store: o, service: "hasq", cfg: OutboxConfig{Stream: "hasq.events", Batch: 2}}
+	r.publish = func(_ context.Context, e OutboxEvent) error {
+		events = append(events, e)
+		return nil
+	}
+	r.drain(context.Background())
+	if len(events) != 5 {
+		t.Fatalf("all events must be published in batches, got %d", len(events))
+	}
+	for i, e := range events {
+		var p OwnershipTransferred
+		if err := json.Unmarshal(e.Payload, &p); err != nil || p.Num != uint64(i) || e.Service != "hasq" {
+			t.Fatalf("event %d: unexpected %+v %v", i, e, err)
+		}
+	}
+
+	r.publish = func(context.Context, OutboxEvent) error { return errors.New("redis is down") }
+	o.Write(EventTokenCreated, "t", TokenCreated{TokenId: "t"})
+	r.drain(context.Background())
+	if !r.failing {
+		t.Fatal("failed publishing must be remembered")
+	}
+}
+
+func TestStreamMessage(t *testing.T) {
+	e := OutboxEvent{
+		Id: 42, Service: "class", Type: EventElementPublished, Aggregate: "colors:red",
+		Payload:   json.RawMessage(`{"class":"colors","key":"red","value":"#f00","version":1}`),
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 123000, time.UTC),
+	}
+	got, err := parseStreamMessage(redis.XMessage{ID: "1-0", Values: streamValues(e)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != e.Id || got.Service != e.Service || got.Type != e.Type || got.Aggregate != e.Aggregate ||
+		string(got.Payload) != string(e.Payload) || !got.CreatedAt.Equal(e.CreatedAt) {
+		t.Fatalf("%+v expected, got %+v", e, got)
+	}
+	if _, err = parseStreamMessage(redis.XMessage{ID: "1-0", Values: map[string]any{"id": "x"}}); err == nil {
+		t.Fatal("invalid message must not be parsed")
+	}
+}
+
+func TestStreamConsumer(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL is not set")
+	}
+	cfg := DefaultConfig("test-"+uuid.NewString(), 50051)
+	cfg.Redis.Url = url
+	client, err := RedisClient(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Del(context.Background(), cfg.Outbox.Stream).Err()
+		_ = client.Close()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := int64(1); i <= 3; i++ {
+		e := OutboxEvent{Id: i, Service: cfg.Service, Type: EventTokenCreated, Payload: json.RawMessage(`{}`), CreatedAt: time.Now()}
+		if err = PublishEvent(ctx, client, cfg.Outbox.Stream, 0, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &StreamConsumer{Client: client, Stream: cfg.Outbox.Stream, Group: "test", Name: "c1",
+		MinIdle: 100 * time.Millisecond, Block: 100 * time.Millisecond}
+	seen := make(map[int64]int)
+	handled := make(chan struct{})
+	run, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		_ = c.Run(run, func(_ context.Context, e OutboxEvent) error {
+			seen[e.Id]++
+			// Первая обработка второго события неудачна, оно приходит повторно
+			if e.Id == 2 && seen[e.Id] == 1 {
+				return errors.New("not now")
+			}
+			if len(seen) == 3 && seen[2] == 2 {
+				close(handled)
+			}
+			return nil
+		})
+	}()
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		t.Fatal("all events must be handled")
+	}
+	// Сообщение подтверждается после возврата обработчика
+	for {
+		pending, err := client.XPending(ctx, cfg.Outbox.Stream, "test").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending.Count == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("all messages must be acked, %d pending", pending.Count)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// RateLimitRedis корзины хранятся в Redis и общие для всех экземпляров сервиса
+	RateLimitRedis = "redis"
+	// RateLimitMemory корзины хранятся в памяти каждого экземпляра
+	RateLimitMemory = "memory"
+
+	// RetryAfterHeader трейлер отклоненного вызова с числом секунд до
+	// появления свободного токена
+	RetryAfterHeader = "retry-after"
+
+	// rateLimitAnyMethod лимит методов без своего лимита
+	rateLimitAnyMethod = "*"
+	// bucketsSweep число корзин в памяти, после которого удаляются заполненные
+	bucketsSweep = 10000
+	// rateLimitRedisTimeout наибольшее время вызова Redis при проверке лимита
+	rateLimitRedisTimeout = 100 * time.Millisecond
+	// rateLimitRedisBackoff время, на которое вызовы ограничиваются корзинами
+	// в памяти после ошибки Redis
+	rateLimitRedisBackoff = 5 * time.Second
+)
+
+type RateLimitConfig struct {
+	// Limits лимиты методов через запятую в виде метод=скорость[:емкость], где
+	// скорость число вызовов в секунду, а емкость наибольший всплеск. Метод
+	// задается полным именем /hasq.Service/Owned, сервисом /hasq.Service/*
+	// или * для остальных. Пустая строка отключает ограничение.
+	Limits string
+	// Backend хранилище корзин: redis с запасным хранилищем в памяти или memory
+	Backend string
+}
+
+// rateLimit скорость пополнения и емкость корзины токенов
+type rateLimit struct {
+	rate  float64
+	burst float64
+}
+
+// parseRateLimits разбирает RateLimitConfig.Limits
+func parseRateLimits(value string) (map[string]rateLimit, error) {
+	limits := make(map[string]rateLimit)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		method, spec, ok := strings.Cut(item, "=")
+		if !ok || (method != rateLimitAnyMethod && !strings.HasPrefix(method, "/")) {
+			return nil, fmt.Errorf("invalid rate limit %q", item)
+		}
+		rate, burst, hasBurst := strings.Cut(spec, ":")
+		var limit rateLimit
+		var err error
+		if limit.rate, err = strconv.ParseFloat(rate, 64); err != nil || limit.rate <= 0 {
+			return nil, fmt.Errorf("invalid rate of %q", item)
+		}
+		limit.burst = math.Ceil(limit.rate)
+		if hasBurst {
+			if limit.burst, err = strconv.ParseFloat(burst, 64); err != nil || limit.burst < 1 {
+				return nil, fmt.Errorf("invalid burst of %q", item)
+			}
+		}
+		limits[method] = limit
+	}
+	return limits, nil
+}
+
+// bucketStore списывает n токенов из корзины key, если в ней есть целый
+// токен, и возвращает, через сколько он появится, если корзина пуста. При n
+// равном нулю корзина только проверяется.
+type bucketStore interface {
+	take(ctx context.Context, key string, limit rateLimit, n float64) (bool, time.Duration, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// limit лимит последнего вызова, по нему корзина считается заполненной
+	limit rateLimit
+}
+
+// memoryBuckets корзины токенов в памяти процесса
+type memoryBuckets struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func newMemoryBuckets() *memoryBuckets {
+	return &memoryBuckets{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (m *memoryBuckets) take(_ context.Context, key string, limit rateLimit, n float64) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if len(m.buckets) >= bucketsSweep {
+		m.sweep(now)
+	}
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.burst, updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(limit.burst, b.tokens+now.Sub(b.updated).Seconds()*limit.rate)
+	b.updated = now
+	b.limit = limit
+	if b.tokens >= 1 {
+		b.tokens -= n
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) / limit.rate * float64(time.Second)), nil
+}
+
+// sweep удаляет корзины, которые успели бы заполниться и не отличаются от новых
+func (m *memoryBuckets) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if now.Sub(b.updated) > time.Duration(b.limit.burst/b.limit.rate*float64(time.Second)) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// takeScript списывает ARGV[3] токенов из корзины KEYS[1] со скоростью ARGV[1]
+// и емкостью ARGV[2] по часам Redis, чтобы экземпляры сервиса с разным временем
+// делили одну корзину. Возвращает 1 или 0 и миллисекунды до свободного токена.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate / 1000)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - n
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, wait}
+`)
+
+// redisBuckets корзины токенов в Redis. После ошибки Redis вызовы на время
+// rateLimitRedisBackoff ограничиваются корзинами в памяти, не дожидаясь
+// тайм-аутов подключения.
+type redisBuckets struct {
+	c        *redis.Client
+	prefix   string
+	fallback *memoryBuckets
+	now      func() time.Time
+	mu       sync.Mutex
+	// down Redis недоступен, в журнал пишется только смена состояния
+	down bool
+	// retry время следующей попытки обратиться к Redis
+	retry time.Time
+}
+
+func newRedisBuckets(c *redis.Client, prefix string) *redisBuckets {
+	return &redisBuckets{c: c, prefix: prefix, fallback: newMemoryBuckets(), now: time.Now}
+}
+
+func (r *redisBuckets) take(ctx context.Context, key string, limit rateLimit, n float64) (bool, time.Duration, error) {
+	if r.skip() {
+		return r.fallback.take(ctx, key, limit, n)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, rateLimitRedisTimeout)
+	defer cancel()
+	result, err := takeScript.Run(callCtx, r.c, []string{r.prefix + key},
+		strconv.FormatFloat(limit.rate, 'f', -1, 64), strconv.FormatFloat(limit.burst, 'f', -1, 64),
+		strconv.FormatFloat(n, 'f', -1, 64)).Int64Slice()
+	if err == nil && len(result) != 2 {
+		err = fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+	r.setDown(ctx, err)
+	if err != nil {
+		return r.fallback.take(ctx, key, limit, n)
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// skip сообщает, что Redis недоступен и время следующей попытки не наступило
+func (r *redisBuckets) skip() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.down && r.now().Before(r.retry)
+}
+
+func (r *redisBuckets) setDown(ctx context.Context, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.retry = r.now().Add(rateLimitRedisBackoff)
+	}
+	if down := err != nil; down != r.down {
+		r.down = down
+		if down {
+			slog.WarnContext(ctx, "Rate limit falls back to memory",
+				slog.Duration("retry", rateLimitRedisBackoff), slog.String("err", err.Error()))
+		} else {
+			slog.InfoContext(ctx, "Rate limit uses redis again")
+		}
+	}
+}
+
+// RateLimiter ограничивает частоту вызовов корзинами токенов по методу и
+// клиенту: аутентифицированному пользователю или адресу узла. До
+// аутентификации отдельные корзины узлов ограничивают неудачные попытки,
+// чтобы поток неверных учетных данных не доходил до их проверки.
+type RateLimiter struct {
+	limits    map[string]rateLimit
+	store     bucketStore
+	client    *redis.Client
+	throttled metric.Int64Counter
+}
+
+// NewRateLimiter создает ограничитель по cfg.RateLimit. Подключение к Redis
+// устанавливается при первом вызове.
+func NewRateLimiter(cfg *Config) (*RateLimiter, error) {
+	limits, err := parseRateLimits(cfg.RateLimit.Limits)
+	if err != nil {
+		return nil, err
+	}
+	l := &RateLimiter{limits: limits}
+	if len(limits) == 0 {
+		return l, nil
+	}
+	l.throttled, err = otel.Meter("pet/services").Int64Counter("rpc.server.throttled",
+		metric.WithDescription("Number of gRPC calls rejected by the rate limit"))
+	if err != nil {
+		slog.Error("Can't create rpc throttled counter", slog.String("err", err.Error()))
+	}
+	switch cfg.RateLimit.Backend {
+	case RateLimitMemory:
+		l.store = newMemoryBuckets()
+	case RateLimitRedis:
+		if l.client, err = RedisClient(cfg); err != nil {
+			return nil, err
+		}
+		l.store = newRedisBuckets(l.client, cfg.Service+":ratelimit:")
+	default:
+		return nil, fmt.Errorf("unsupported rate limit backend %q", cfg.RateLimit.Backend)
+	}
+	return l, nil
+}
+
+// enabled сообщает, что для ограничителя заданы лимиты
+func (l *RateLimiter) enabled() bool {
+	return l != nil && len(l.limits) > 0
+}
+
+func (l *RateLimiter) Close() error {
+	if l.client != nil {
+		return l.client.Close()
+	}
+	return nil
+}
+
+// limit лимит метода, его сервиса или общий
+func (l *RateLimiter) limit(method string) (rateLimit, bool) {
+	if limit, ok := l.limits[method]; ok {
+		return limit, true
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		if limit, ok := l.limits[method[:i]+"/*"]; ok {
+			return limit, true
+		}
+	}
+	limit, ok := l.limits[rateLimitAnyMethod]
+	return limit, ok
+}
+
+// caller ключ клиента: пользователь, если он аутентифицирован, иначе адрес
+// узла. Поле user_id запроса не используется, его задает сам клиент.
+func caller(ctx context.Context) string {
+	if identity, ok := IdentityFrom(ctx); ok {
+		return "user:" + identity.Subject
+	}
+	return peerKey(ctx)
+}
+
+// peerKey ключ адреса узла клиента
+func peerKey(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		return "peer:" + host
+	}
+	return "peer:unknown"
+}
+
+// allow списывает токен вызова method. Отказ возвращается как
+// ResourceExhausted с RetryInfo и трейлером retry-after.
+func (l *RateLimiter) allow(ctx context.Context, method string) error {
+	if strings.HasPrefix(method, "/grpc.health.v1.") {
+		return nil
+	}
+	limit, ok := l.limit(method)
+	if !ok {
+		return nil
+	}
+	allowed, wait, err := l.store.take(ctx, method+":"+caller(ctx), limit, 1)
+	if err != nil || allowed {
+		return nil
+	}
+	return l.reject(ctx, method, wait)
+}
+
+// guard выполняет call, если у узла клиента остались попытки аутентификации
+// вызова method. Проверка не списывает токен, его списывает только отказ в
+// аутентификации, поэтому клиенты с верными учетными данными за одним шлюзом
+// не расходуют попытки узла.
+func (l *RateLimiter) guard(ctx context.Context, method string, call func() error) error {
+	limit, ok := l.limit(method)
+	if !ok || strings.HasPrefix(method, "/grpc.health.v1.") {
+		return call()
+	}
+	key := method + ":unauthenticated:" + peerKey(ctx)
+	if allowed, wait, err := l.store.take(ctx, key, limit, 0); err == nil && !allowed {
+		return l.reject(ctx, method, wait)
+	}
+	err := call()
+	if status.Code(err) == codes.Unauthenticated {
+		_, _, _ = l.store.take(ctx, key, limit, 1)
+	}
+	return err
+}
+
+// reject отказ вызова method, свободный токен появится через wait
+func (l *RateLimiter) reject(ctx context.Context, method string, wait time.Duration) error {
+	if l.throttled != nil {
+		l.throttled.Add(ctx, 1, metric.WithAttributes(attribute.String("rpc.method", method)))
+	}
+	seconds := int64(math.Ceil(wait.Seconds()))
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(RetryAfterHeader, strconv.FormatInt(max(seconds, 1), 10)))
+	s := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	if detailed, err := s.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+		s = detailed
+	}
+	return s.Err()
+}
+
+func (l *RateLimiter) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := l.allow(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (l *RateLimiter) stream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := l.allow(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+func (l *RateLimiter) guardUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (reply any, err error) {
+	err = l.guard(ctx, info.FullMethod, func() error {
+		reply, err = handler(ctx, req)
+		return err
+	})
+	return reply, err
+}
+
+func (l *RateLimiter) guardStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return l.guard(stream.Context(), info.FullMethod, func() error {
+		return handler(srv, stream)
+	})
+}
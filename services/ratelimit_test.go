@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits(" *=100:200, /hasq.Service/*=10, /hasq.Service/Owned=0.5:2 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &RateLimiter{limits: limits}
+	for method, want := range map[string]rateLimit{
+		"/hasq.Service/Owned":       {rate: 0.5, burst: 2},
+		"/hasq.Service/CreateToken": {rate: 10, burst: 10},
+		"/class.Service/ListClass":  {rate: 100, burst: 200},
+	} {
+		if got, ok := l.limit(method); !ok || got != want {
+			t.Fatalf("%s: %+v expected, got %+v", method, want, got)
+		}
+	}
+	if limits, _ = parseRateLimits("/hasq.Service/Owned=1"); len(limits) != 1 {
+		t.Fatalf("one limit expected, got %v", limits)
+	}
+	if _, ok := (&RateLimiter{limits: limits}).limit("/class.Service/ListClass"); ok {
+		t.Fatal("methods without a limit must not be limited")
+	}
+}
+
+func TestMemoryBuckets(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryBuckets()
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return clock }
+	limit := rateLimit{rate: 2, burst: 3}
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := m.take(ctx, "a", limit, 1); !ok {
+			t.Fatalf("call %d within burst rejected", i)
+		}
+	}
+	ok, wait, _ := m.take(ctx, "a", limit, 1)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("call over burst must wait 500ms, got %v %v", ok, wait)
+	}
+	if ok, _, _ = m.take(ctx, "b", limit, 1); !ok {
+		t.Fatal("other key has its own bucket")
+	}
+	clock = clock.Add(500 * time.Millisecond)
+	if ok, _, _ = m.take(ctx, "a", limit, 1); !ok {
+		t.Fatal("refilled token rejected")
+	}
+}
+
+func TestMemoryBuckets_Sweep(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryBuckets()
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return clock }
+	slow := rateLimit{rate: 0.01, burst: 1}
+	if ok, _, _ := m.take(ctx, "slow", slow, 1); !ok {
+		t.Fatal("first call rejected")
+	}
+	for i := 0; len(m.buckets) < bucketsSweep; i++ {
+		_, _, _ = m.take(ctx, strconv.Itoa(i), rateLimit{rate: 100, burst: 1}, 1)
+	}
+	// Корзины быстрого метода уже заполнены, а медленная еще нет
+	clock = clock.Add(time.Second)
+	_, _, _ = m.take(ctx, "fast", rateLimit{rate: 100, burst: 1}, 1)
+	if _, ok := m.buckets["slow"]; !ok || len(m.buckets) != 2 {
+		t.Fatalf("only the depleted slow bucket must stay, got %d buckets", len(m.buckets))
+	}
+	if ok, _, _ := m.take(ctx, "slow", slow, 1); ok {
+		t.Fatal("sweep must not refill the slow bucket")
+	}
+}
+
+func TestRedisBuckets(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL is not set")
+	}
+	cfg := DefaultConfig("test-"+uuid.NewString(), 50051)
+	cfg.Redis.Url = url
+	cfg.RateLimit.Limits = "*=1:2"
+	l, err := NewRateLimiter(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	ctx := context.Background()
+	limit := rateLimit{rate: 1, burst: 2}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := l.store.take(ctx, "a", limit, 1); err != nil || !ok {
+			t.Fatalf("call %d within burst rejected: %v", i, err)
+		}
+	}
+	ok, wait, err := l.store.take(ctx, "a", limit, 1)
+	if err != nil || ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("call over burst must wait up to 1s, got %v %v %v", ok, wait, err)
+	}
+	if l.store.(*redisBuckets).down {
+		t.Fatal("redis must be used")
+	}
+}
+
+func TestRedisBuckets_Fallback(t *testing.T) {
+	cfg := DefaultConfig("test", 50051)
+	cfg.Redis.Url = "redis://127.0.0.1:1/0"
+	cfg.RateLimit.Limits = "*=1:1"
+	l, err := NewRateLimiter(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	ctx := context.Background()
+	if ok, _, err := l.store.take(ctx, "a", rateLimit{rate: 1, burst: 1}, 1); err != nil || !ok {
+		t.Fatalf("memory bucket expected, got %v %v", ok, err)
+	}
+	r := l.store.(*redisBuckets)
+	if !r.down || !r.skip() {
+		t.Fatal("redis must be skipped after an error")
+	}
+	// До конца паузы Redis не вызывается, даже если его клиент закрыт
+	_ = l.client.Close()
+	if ok, _, _ := l.store.take(ctx, "a", rateLimit{rate: 1, burst: 1}, 1); ok {
+		t.Fatal("memory bucket must limit calls while redis is down")
+	}
+	r.now = func() time.Time { return time.Now().Add(rateLimitRedisBackoff) }
+	if r.skip() {
+		t.Fatal("redis must be retried after the backoff")
+	}
+}
+
+func TestRateLimiter_Server(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig("test", 50051)
+	cfg.Auth = AuthConfig{Mode: AuthJwt, JwtSecret: testSecret}
+	cfg.RateLimit = RateLimitConfig{Limits: "/pet.WhoAmI/*=0.1:1", Backend: RateLimitMemory}
+	l, err := NewRateLimiter(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewGRPCServer(&cfg, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &whoAmI{subject: make(chan string, 10)}
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "pet.WhoAmI",
+		HandlerType: (*healthpb.HealthServer)(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Check", Handler: healthCheckHandler}},
+	}, w)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call := func(user string) (metadata.MD, error) {
+		token := signHS256(t, claims(user, time.Hour, nil))
+		var trailer metadata.MD
+		err := conn.Invoke(metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token),
+			"/pet.WhoAmI/Check", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{}, grpc.Trailer(&trailer))
+		return trailer, err
+	}
+	if _, err = call("u1"); err != nil {
+		t.Fatal(err)
+	}
+	trailer, err := call("u1")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("ResourceExhausted expected, got %v", err)
+	}
+	if retry := trailer.Get(RetryAfterHeader); len(retry) != 1 || retry[0] != "10" {
+		t.Fatalf("retry-after 10 expected, got %v", retry)
+	}
+	details := status.Convert(err).Details()
+	if len(details) != 1 {
+		t.Fatalf("RetryInfo expected, got %v", details)
+	}
+	if info, ok := details[0].(*errdetails.RetryInfo); !ok || info.GetRetryDelay().AsDuration() <= 9*time.Second {
+		t.Fatalf("retry delay about 10s expected, got %v", details[0])
+	}
+	// Лимит считается для каждого пользователя отдельно
+	if _, err = call("u2"); err != nil {
+		t.Fatal(err)
+	}
+	// Успешные вызовы не расходуют попытки узла, а неудачные ограничиваются
+	// по узлу до проверки токена
+	invalid := metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer invalid")
+	if err = conn.Invoke(invalid, "/pet.WhoAmI/Check", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Unauthenticated expected, got %v", err)
+	}
+	if err = conn.Invoke(invalid, "/pet.WhoAmI/Check", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("ResourceExhausted expected for the peer, got %v", err)
+	}
+}
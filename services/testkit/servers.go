@@ -0,0 +1,138 @@
+package testkit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTimeout время ожидания запуска локального сервера
+const startTimeout = 30 * time.Second
+
+// errNotInstalled исполняемые файлы сервера не найдены
+var errNotInstalled = errors.New("is not found")
+
+var postgresServer = sync.OnceValues(startPostgres)
+
+// Postgres возвращает URL сервера Postgres для тестов, запуская его при
+// первом вызове. Тест пропускается, если Postgres не установлен, и
+// завершается с ошибкой, если установленный сервер не запустился.
+func Postgres(t testing.TB) string {
+	t.Helper()
+	url, err := postgresServer()
+	if errors.Is(err, errNotInstalled) {
+		t.Skipf("Postgres is not available: %v", err)
+	} else if err != nil {
+		t.Fatalf("Postgres is not started: %v", err)
+	}
+	return url
+}
+
+func startPostgres() (string, error) {
+	if url, ok := os.LookupEnv("TEST_DATABASE_URL"); ok {
+		return url, nil
+	}
+	initdb, err := lookBinary("initdb")
+	if err != nil {
+		return "", err
+	}
+	postgres, err := lookBinary("postgres")
+	if err != nil {
+		return "", err
+	}
+	if os.Geteuid() == 0 {
+		return "", errors.New("initdb can't run as root, run tests as a regular user or set TEST_DATABASE_URL")
+	}
+	dir, err := os.MkdirTemp("", "testkit-postgres-")
+	if err != nil {
+		return "", err
+	}
+	data := filepath.Join(dir, "data")
+	out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8",
+		"--no-sync").CombinedOutput()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("initdb: %w: %s", err, out)
+	}
+	port, err := freePort()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	// Надежность записи не нужна тестам, а отключение fsync ускоряет миграции
+	err = startServer(dir, port, postgres, "-D", data, "-p", strconv.Itoa(port), "-h", "127.0.0.1",
+		"-k", dir, "-c", "fsync=off", "-c", "synchronous_commit=off", "-c", "full_page_writes=off")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port), nil
+}
+
+// startServer запускает сервер name с рабочим каталогом dir и ожидает, пока
+// он не начнет слушать port. Вывод сервера пишется в dir/server.log. После
+// тестов сервер останавливается, а dir удаляется.
+func startServer(dir string, port int, name string, args ...string) error {
+	output, err := os.Create(filepath.Join(dir, "server.log"))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = output, output
+	if err = cmd.Start(); err != nil {
+		_ = output.Close()
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	stop := func() {
+		_ = cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-exited:
+		case <-time.After(10 * time.Second):
+			_ = cmd.Process.Kill()
+			<-exited
+		}
+		_ = output.Close()
+		_ = os.RemoveAll(dir)
+	}
+	if err = waitPort(port, startTimeout); err != nil {
+		log, _ := os.ReadFile(output.Name())
+		stop()
+		return fmt.Errorf("%s is not started: %w: %s", filepath.Base(name), err, log)
+	}
+	onExit(stop)
+	return nil
+}
+
+// lookBinary ищет исполняемый файл в PG_BIN, PATH и каталогах установленных
+// версий Postgres, начиная с последней
+func lookBinary(name string) (string, error) {
+	if dir, ok := os.LookupEnv("PG_BIN"); ok {
+		if path, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			return path, nil
+		}
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	dirs, _ := filepath.Glob("/usr/lib/postgresql/*/bin")
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if path, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s %w", name, errNotInstalled)
+}
@@ -0,0 +1,158 @@
+// Package testkit запускает для интеграционных тестов Postgres и выдает
+// каждому тесту отдельную схему базы с примененными миграциями.
+//
+// Сервер берется из TEST_DATABASE_URL, а если он не задан, запускается из
+// исполняемых файлов initdb и postgres, найденных в PG_BIN, PATH или
+// /usr/lib/postgresql/*/bin. initdb не работает от root, поэтому такие тесты
+// запускаются обычным пользователем. Запущенный сервер живет до конца тестов
+// пакета, поэтому пакет с интеграционными тестами вызывает Run:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(testkit.Run(m))
+//	}
+//
+// Если Postgres не установлен, тест пропускается.
+package testkit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pet/services"
+	"pet/services/migrations"
+)
+
+var (
+	stopMu sync.Mutex
+	stops  []func()
+)
+
+// onExit регистрирует остановку сервера после тестов пакета
+func onExit(stop func()) {
+	stopMu.Lock()
+	defer stopMu.Unlock()
+	stops = append(stops, stop)
+}
+
+// Run выполняет тесты пакета и останавливает запущенные для них серверы
+func Run(m *testing.M) int {
+	code := m.Run()
+	stopMu.Lock()
+	defer stopMu.Unlock()
+	for i := len(stops) - 1; i >= 0; i-- {
+		stops[i]()
+	}
+	stops = nil
+	return code
+}
+
+var schemas atomic.Int64
+
+// Config возвращает конфигурацию сервиса service с новой пустой схемой в
+// search_path подключения к базе. Схема удаляется после теста.
+func Config(t testing.TB, service string) *services.Config {
+	t.Helper()
+	server := Postgres(t)
+	ctx := context.Background()
+	admin, err := adminDatabase(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("test_%s_%d_%d", service, time.Now().UnixNano(), schemas.Add(1))
+	if _, err = admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Logf("Can't drop schema %s: %v", schema, err)
+		}
+	})
+
+	u, err := url.Parse(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	cfg := services.DefaultConfig(service, 50051)
+	cfg.Database.Url = u.String()
+	cfg.Database.ConnectTimeout = 10 * time.Second
+	return &cfg
+}
+
+// Database возвращает подключение к новой схеме с примененными миграциями
+// сервиса service. Подключение закрывается, а схема удаляется после теста.
+func Database(t testing.TB, service string) *sql.DB {
+	t.Helper()
+	source, err := migrations.For(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config(t, service)
+	db, err := services.NewDatabase(context.Background(), cfg, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+var admins sync.Map
+
+// adminDatabase общий пул подключений к серверу для создания схем
+func adminDatabase(ctx context.Context, server string) (*sql.DB, error) {
+	if db, ok := admins.Load(server); ok {
+		return db.(*sql.DB), nil
+	}
+	cfg := services.DefaultConfig("testkit", 50051)
+	cfg.Database.Url = server
+	cfg.Database.ConnectTimeout = 10 * time.Second
+	db, err := services.OpenDatabase(ctx, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if existing, loaded := admins.LoadOrStore(server, db); loaded {
+		_ = db.Close()
+		return existing.(*sql.DB), nil
+	}
+	onExit(func() {
+		admins.Delete(server)
+		_ = db.Close()
+	})
+	return db, nil
+}
+
+// freePort свободный TCP порт локального интерфейса
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = l.Close() }()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// waitPort ожидает, пока локальный порт port не начнет принимать подключения
+func waitPort(port int, timeout time.Duration) error {
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err == nil {
+			return conn.Close()
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
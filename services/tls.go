@@ -0,0 +1,223 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// TlsClientNone сертификат клиента не запрашивается
+	TlsClientNone = "none"
+	// TlsClientOptional сертификат клиента проверяется, если передан
+	TlsClientOptional = "optional"
+	// TlsClientRequire без проверенного сертификата клиента соединение отклоняется
+	TlsClientRequire = "require"
+)
+
+// tlsReloadInterval как часто при новых соединениях проверяется изменение
+// файлов сертификатов
+var tlsReloadInterval = 10 * time.Second
+
+type TlsConfig struct {
+	// CertFile и KeyFile сертификат и ключ сервера в PEM, без них TLS выключен
+	CertFile string
+	KeyFile  string
+	// ClientCAFile корневые сертификаты для проверки сертификатов клиентов
+	ClientCAFile string
+	// ClientAuth проверка сертификата клиента: none, optional или require
+	ClientAuth string
+}
+
+// Enabled сервер принимает только соединения TLS
+func (c TlsConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// ClientTlsConfig параметры TLS клиента сервисов
+type ClientTlsConfig struct {
+	// CAFile корневые сертификаты сервера, по умолчанию системные
+	CAFile string
+	// CertFile и KeyFile сертификат клиента для mTLS
+	CertFile string
+	KeyFile  string
+	// ServerName имя в сертификате сервера, если оно отличается от адреса
+	ServerName string
+}
+
+// Enabled соединение с сервисом устанавливается по TLS
+func (c ClientTlsConfig) Enabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.ServerName != ""
+}
+
+// certReloader перечитывает сертификат, ключ и корневые сертификаты, когда
+// меняется время изменения их файлов. При ошибке чтения остаются прежние.
+type certReloader struct {
+	certFile, keyFile, caFile string
+
+	mu      sync.Mutex
+	checked time.Time
+	stamp   []time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	stamp, err := r.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.load(stamp); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files непустые имена файлов
+func (r *certReloader) files() []string {
+	var files []string
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// modTimes время изменения файлов
+func (r *certReloader) modTimes() ([]time.Time, error) {
+	var stamp []time.Time
+	for _, name := range r.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		stamp = append(stamp, info.ModTime())
+	}
+	return stamp, nil
+}
+
+func (r *certReloader) load(stamp []time.Time) error {
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates in %s", r.caFile)
+		}
+	}
+	r.cert, r.pool, r.stamp = cert, pool, stamp
+	return nil
+}
+
+// current сертификат и корневые сертификаты, перечитанные при изменении файлов
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) < tlsReloadInterval {
+		return r.cert, r.pool
+	}
+	r.checked = time.Now()
+	stamp, err := r.modTimes()
+	if err == nil && !slices.EqualFunc(stamp, r.stamp, time.Time.Equal) {
+		if err = r.load(stamp); err == nil {
+			slog.Info("TLS certificates reloaded", slog.Any("files", r.files()))
+		}
+	}
+	if err != nil {
+		slog.Warn("Can't reload TLS certificates", slog.Any("files", r.files()), slog.String("err", err.Error()))
+	}
+	return r.cert, r.pool
+}
+
+// NewServerTls настройки TLS сервера с перечитыванием сертификатов при
+// изменении файлов
+func NewServerTls(cfg TlsConfig) (*tls.Config, error) {
+	clientAuth := tls.NoClientCert
+	switch cfg.ClientAuth {
+	case "", TlsClientNone:
+	case TlsClientOptional:
+		clientAuth = tls.VerifyClientCertIfGiven
+	case TlsClientRequire:
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unsupported tls client auth %q", cfg.ClientAuth)
+	}
+	if clientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, errors.New("tls client auth requires a client ca file")
+	}
+	r, err := newCertReloader(cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    pool,
+				NextProtos:   []string{"h2"},
+			}, nil
+		},
+	}, nil
+}
+
+// NewClientTls настройки TLS клиента. Сертификат клиента перечитывается
+// при изменении файлов, корневые сертификаты читаются один раз.
+func NewClientTls(cfg ClientTlsConfig) (*tls.Config, error) {
+	c := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: cfg.ServerName}
+	if cfg.CAFile != "" {
+		data, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		r, err := newCertReloader(cfg.CertFile, cfg.KeyFile, "")
+		if err != nil {
+			return nil, err
+		}
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+	}
+	return c, nil
+}
+
+// ClientTransport параметр подключения к сервису по TLS из cfg
+func ClientTransport(cfg ClientTlsConfig) (grpc.DialOption, error) {
+	c, err := NewClientTls(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(c)), nil
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// testCA удостоверяющий центр для сертификатов тестов
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pet test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	ca.write(t, "ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) write(t *testing.T, name, block string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(ca.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: block, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// issue выпускает сертификат name.pem и ключ name.key с CommonName и OU
+func (ca *testCA) issue(t *testing.T, name, commonName string, serial int64, ou ...string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName, OrganizationalUnit: ou},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca.write(t, name+".pem", "CERTIFICATE", der), ca.write(t, name+".key", "EC PRIVATE KEY", keyDer)
+}
+
+// whoAmI сервис health, отвечающий именем аутентифицированного клиента
+type whoAmI struct {
+	healthpb.UnimplementedHealthServer
+	subject chan string
+}
+
+func (w *whoAmI) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	identity, _ := IdentityFrom(ctx)
+	w.subject <- identity.Subject
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func TestNewGRPCServer_Tls(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, "server", "server", 2)
+	clientCert, clientKey := ca.issue(t, "client", "u1", 3, "reader")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig("test", 50051)
+	cfg.Tls = TlsConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: filepath.Join(ca.dir, "ca.pem"),
+		ClientAuth: TlsClientOptional}
+	server, err := NewGRPCServer(&cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	check := func(opt grpc.DialOption) error {
+		conn, err := grpc.NewClient(listener.Addr().String(), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+	if err = check(grpc.WithTransportCredentials(insecure.NewCredentials())); status.Code(err) != codes.Unavailable {
+		t.Fatalf("plaintext connection must fail, got %v", err)
+	}
+	withCA, err := ClientTransport(ClientTlsConfig{CAFile: filepath.Join(ca.dir, "ca.pem")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = check(withCA); err != nil {
+		t.Fatal(err)
+	}
+	withCert, err := ClientTransport(ClientTlsConfig{CAFile: filepath.Join(ca.dir, "ca.pem"),
+		CertFile: clientCert, KeyFile: clientKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = check(withCert); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewGRPCServer_MtlsIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, "server", "server", 2)
+	clientCert, clientKey := ca.issue(t, "client", "u1", 3, "reader")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig("test", 50051)
+	cfg.Tls = TlsConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: filepath.Join(ca.dir, "ca.pem"),
+		ClientAuth: TlsClientRequire}
+	cfg.Auth = AuthConfig{Mode: AuthMtls}
+	server, err := NewGRPCServer(&cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Метод сервиса не из grpc.health.v1 проходит аутентификацию
+	w := &whoAmI{subject: make(chan string, 1)}
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "pet.WhoAmI",
+		HandlerType: (*healthpb.HealthServer)(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Check", Handler: healthCheckHandler}},
+	}, w)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	transport, err := ClientTransport(ClientTlsConfig{CAFile: filepath.Join(ca.dir, "ca.pem"),
+		CertFile: clientCert, KeyFile: clientKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(listener.Addr().String(), transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reply := &healthpb.HealthCheckResponse{}
+	if err = conn.Invoke(ctx, "/pet.WhoAmI/Check", &healthpb.HealthCheckRequest{}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if subject := <-w.subject; subject != "u1" {
+		t.Fatalf("certificate subject expected, got %q", subject)
+	}
+}
+
+func healthCheckHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	req := &healthpb.HealthCheckRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(*whoAmI).Check(ctx, req.(*healthpb.HealthCheckRequest))
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/pet.WhoAmI/Check"}, handler)
+}
+
+func TestCertReloader(t *testing.T) {
+	interval := tlsReloadInterval
+	tlsReloadInterval = 0
+	t.Cleanup(func() { tlsReloadInterval = interval })
+
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, "server", "server", 2)
+	c, err := NewServerTls(TlsConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() int64 {
+		config, err := c.GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+	if n := serial(); n != 2 {
+		t.Fatalf("serial 2 expected, got %d", n)
+	}
+	ca.issue(t, "server", "server", 5)
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err = os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := serial(); n != 5 {
+		t.Fatalf("reloaded serial 5 expected, got %d", n)
+	}
+	// Поврежденный файл не заменяет действующий сертификат
+	if err = os.WriteFile(certFile, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(certFile, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n := serial(); n != 5 {
+		t.Fatalf("previous serial 5 expected, got %d", n)
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracingNone трассировки не экспортируются, контекст трассировки только передается дальше
+	TracingNone = "none"
+	// TracingOtlp трассировки отправляются в OTLP gRPC коллектор
+	TracingOtlp = "otlp"
+	// TracingStdout трассировки печатаются в стандартный вывод
+	TracingStdout = "stdout"
+)
+
+// DefineTracing настраивает глобальный TracerProvider по cfg.Tracing.
+// Параметры OTLP экспортера, не заданные в конфигурации, читаются из
+// стандартных переменных окружения OTEL_EXPORTER_OTLP_*.
+func DefineTracing(l *Lifecycle, cfg *Config) error {
+	definePropagator()
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Tracing.Exporter {
+	case TracingNone:
+		return nil
+	case TracingOtlp:
+		var opts []otlptracegrpc.Option
+		if cfg.Tracing.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Tracing.Endpoint))
+		}
+		exporter, err = otlptracegrpc.New(l.Context(), opts...)
+	case TracingStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		err = fmt.Errorf("unsupported trace exporter %q", cfg.Tracing.Exporter)
+	}
+	if err != nil {
+		return err
+	}
+	DefineTracingExporter(l, cfg, exporter)
+	return nil
+}
+
+// DefineTracingExporter устанавливает глобальный TracerProvider, отправляющий
+// трассировки в exporter, например tracetest.InMemoryExporter в тестах.
+// Оставшиеся трассировки отправляются на этапе PhaseClose остановки l.
+func DefineTracingExporter(l *Lifecycle, cfg *Config, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	definePropagator()
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.Service)))
+	if err != nil {
+		slog.Error("Can't create tracing resource", slog.String("err", err.Error()))
+		res = resource.Default()
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	l.OnStop(PhaseClose, "tracing", provider.Shutdown)
+	slog.Info("Tracing enabled", slog.String("exporter", cfg.Tracing.Exporter))
+	return provider
+}
+
+func definePropagator() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// TraceId идентификатор трассировки текущего запроса или пустая строка
+func TraceId(ctx context.Context) string {
+	if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+		return span.TraceID().String()
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+)
+
+func TestDefineTracing_GRPC(t *testing.T) {
+	cfg := DefaultConfig("test", 50051)
+	l := NewLifecycle(time.Second)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := DefineTracingExporter(l, &cfg, exporter)
+	defer func() {
+		l.Stop()
+		_ = l.Wait()
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewGRPCServer(&cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reflection.Register(server)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+	conn, err := grpc.NewClient(listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx, parent := provider.Tracer("test").Start(ctx, "gateway")
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	_ = stream.CloseSend()
+	_, _ = stream.Recv()
+	parent.End()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if err = provider.ForceFlush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		for _, span := range exporter.GetSpans() {
+			if span.SpanKind.String() == "server" {
+				if span.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+					t.Fatalf("server span %s is not in the gateway trace", span.Name)
+				}
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server span expected, got %d spans", len(exporter.GetSpans()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}